@@ -0,0 +1,52 @@
+package request_logger
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+)
+
+// AnomalyScorer scores how anomalous a request is, e.g. with a model trained
+// on past traffic. Score is called before the request is handled and must
+// not read r.Body, which still belongs to the downstream handler.
+//
+// Scorers are compiled in by registering them from an init function, like
+// redactors:
+//
+//	func init() {
+//		request_logger.RegisterAnomalyScorer("mymodel", myModel{})
+//	}
+//
+// and selected with the anomaly_scorer directive.
+type AnomalyScorer interface {
+	Score(r *http.Request) float64
+}
+
+var (
+	anomalyScorersMu sync.RWMutex
+	anomalyScorers   = map[string]AnomalyScorer{}
+)
+
+// RegisterAnomalyScorer makes a scorer available under name. It panics if
+// name is already registered, so conflicting builds fail at startup.
+func RegisterAnomalyScorer(name string, s AnomalyScorer) {
+	anomalyScorersMu.Lock()
+	defer anomalyScorersMu.Unlock()
+
+	if _, ok := anomalyScorers[name]; ok {
+		panic(fmt.Sprintf("anomaly scorer already registered: %s", name))
+	}
+	anomalyScorers[name] = s
+}
+
+// lookupAnomalyScorer returns the scorer registered under name
+func lookupAnomalyScorer(name string) (AnomalyScorer, error) {
+	anomalyScorersMu.RLock()
+	defer anomalyScorersMu.RUnlock()
+
+	s, ok := anomalyScorers[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown anomaly scorer %q", name)
+	}
+	return s, nil
+}
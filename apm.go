@@ -0,0 +1,82 @@
+package request_logger
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"go.uber.org/zap"
+)
+
+// routeVar is the request var holding the matched route template, e.g. set
+// with `vars route /users/{id}`
+const routeVar = "route"
+
+// transactionFields returns an APM transaction document for a completed
+// request, using the field names shared by Elastic APM and Datadog's
+// Elastic-compatible intake
+func transactionFields(r *http.Request, traceID string, status int, err error, elapsed time.Duration) []zap.Field {
+	outcome := "success"
+	if err != nil || status >= 500 {
+		outcome = "failure"
+	}
+
+	transaction := []zap.Field{
+		zap.String("name", r.Method+" "+requestRoute(r)),
+		zap.String("type", "request"),
+		zap.String("result", fmt.Sprintf("HTTP %dxx", status/100)),
+		zap.Dict("duration", zap.Int64("us", elapsed.Microseconds())),
+	}
+	if traceID != "" {
+		transaction = append(transaction, zap.String("id", traceID))
+	}
+
+	return []zap.Field{
+		zap.Dict("transaction", transaction...),
+		zap.Dict("event", zap.String("outcome", outcome)),
+		zap.Dict("http",
+			zap.Dict("request", zap.String("method", r.Method)),
+			zap.Dict("response", zap.Int("status_code", status)),
+		),
+		zap.Dict("url", zap.String("path", r.URL.Path)),
+	}
+}
+
+// requestRoute returns the route template of the request: the route var if
+// a preceding handler set one, otherwise the path with segments that look
+// like identifiers replaced by {id}, to keep transaction names low in
+// cardinality
+func requestRoute(r *http.Request) string {
+	if route, ok := caddyhttp.GetVar(r.Context(), routeVar).(string); ok && route != "" {
+		return route
+	}
+
+	segments := strings.Split(r.URL.Path, "/")
+	for i, segment := range segments {
+		if isIdentifierSegment(segment) {
+			segments[i] = "{id}"
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+// isIdentifierSegment reports whether a path segment is numeric or a long
+// hex string such as a UUID or object ID
+func isIdentifierSegment(segment string) bool {
+	if segment == "" {
+		return false
+	}
+	digits := true
+	for _, c := range segment {
+		switch {
+		case c >= '0' && c <= '9':
+		case c >= 'a' && c <= 'f', c >= 'A' && c <= 'F', c == '-':
+			digits = false
+		default:
+			return false
+		}
+	}
+	return digits || len(segment) >= 16
+}
@@ -0,0 +1,212 @@
+package request_logger
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// artifactQueueSize is the number of pending uploads buffered before new
+// artifacts are dropped
+const artifactQueueSize = 128
+
+// artifactPutTimeout bounds a single upload
+const artifactPutTimeout = time.Minute
+
+// artifactStore persists captured request bodies outside of the log stream
+type artifactStore interface {
+	// Locate returns the URL the named artifact will be stored at
+	Locate(name string) string
+
+	// Put stores the artifact under name
+	Put(ctx context.Context, name string, body []byte) error
+}
+
+// newArtifactStore creates a store for the given location. Supported schemes
+// are file:// (a local directory) and http(s):// (objects are uploaded with
+// PUT, e.g. to an S3-compatible gateway or a pre-authorized bucket URL).
+func newArtifactStore(location string) (artifactStore, error) {
+	u, err := url.Parse(location)
+	if err != nil {
+		return nil, fmt.Errorf("invalid artifact store %q: %v", location, err)
+	}
+
+	switch u.Scheme {
+	case "file":
+		dir := filepath.FromSlash(u.Path)
+		if err := os.MkdirAll(dir, 0o750); err != nil {
+			return nil, fmt.Errorf("creating artifact directory: %v", err)
+		}
+		return fileArtifactStore{dir: dir}, nil
+	case "http", "https":
+		return httpArtifactStore{
+			base:   u,
+			client: &http.Client{Timeout: 30 * time.Second},
+		}, nil
+	default:
+		return nil, fmt.Errorf("unsupported artifact store scheme %q (use file:// or an http(s):// upload endpoint)", u.Scheme)
+	}
+}
+
+// fileArtifactStore writes artifacts to a local directory
+type fileArtifactStore struct {
+	dir string
+}
+
+func (s fileArtifactStore) Locate(name string) string {
+	return (&url.URL{Scheme: "file", Path: filepath.ToSlash(filepath.Join(s.dir, name))}).String()
+}
+
+func (s fileArtifactStore) Put(_ context.Context, name string, body []byte) error {
+	return os.WriteFile(filepath.Join(s.dir, name), body, 0o640)
+}
+
+// httpArtifactStore uploads artifacts with HTTP PUT below a base URL
+type httpArtifactStore struct {
+	base   *url.URL
+	client *http.Client
+}
+
+func (s httpArtifactStore) Locate(name string) string {
+	u := *s.base
+	u.Path = path.Join("/", u.Path, name)
+	return u.String()
+}
+
+func (s httpArtifactStore) Put(ctx context.Context, name string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, s.Locate(name), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+// artifactUpload is a pending upload
+type artifactUpload struct {
+	name string
+	body []byte
+}
+
+// artifactUploader uploads artifacts asynchronously on a single worker so
+// slow storage never blocks request handling
+type artifactUploader struct {
+	store  artifactStore
+	logger *zap.Logger
+	queue  chan artifactUpload
+	done   chan struct{}
+
+	// ctx is cancelled when Close gives up waiting for pending uploads
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu     sync.RWMutex
+	closed bool
+}
+
+func newArtifactUploader(store artifactStore, logger *zap.Logger) *artifactUploader {
+	ctx, cancel := context.WithCancel(context.Background())
+	u := &artifactUploader{
+		store:  store,
+		logger: logger,
+		queue:  make(chan artifactUpload, artifactQueueSize),
+		done:   make(chan struct{}),
+		ctx:    ctx,
+		cancel: cancel,
+	}
+	go u.run()
+	return u
+}
+
+// Enqueue schedules body for upload and returns the URL it will be stored
+// at, or an empty string if the queue is full or the uploader is closed,
+// as it is for requests still in flight during a config reload
+func (u *artifactUploader) Enqueue(body []byte) string {
+	name := artifactName(time.Now())
+
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+	if u.closed {
+		return ""
+	}
+	select {
+	case u.queue <- artifactUpload{name: name, body: body}:
+		return u.store.Locate(name)
+	default:
+		u.logger.Warn("artifact upload queue full, dropping body", zap.Int("size", len(body)))
+		return ""
+	}
+}
+
+// Close stops accepting uploads and waits until deadline for queued uploads
+// to finish. Uploads still pending at the deadline are abandoned.
+func (u *artifactUploader) Close(deadline time.Time) {
+	u.mu.Lock()
+	if u.closed {
+		u.mu.Unlock()
+		return
+	}
+	u.closed = true
+	close(u.queue)
+	u.mu.Unlock()
+
+	if !waitUntil(u.done, deadline) {
+		u.cancel()
+		<-u.done
+		u.logger.Warn("artifact uploads abandoned at shutdown")
+	}
+	u.cancel()
+}
+
+func (u *artifactUploader) run() {
+	defer close(u.done)
+	for upload := range u.queue {
+		if u.ctx.Err() != nil {
+			continue
+		}
+		u.put(u.ctx, upload)
+	}
+}
+
+// put stores one artifact and returns its URL, or an empty string if it
+// could not be stored
+func (u *artifactUploader) put(ctx context.Context, upload artifactUpload) string {
+	ctx, cancel := context.WithTimeout(ctx, artifactPutTimeout)
+	defer cancel()
+	if err := u.store.Put(ctx, upload.name, upload.body); err != nil {
+		u.logger.Error("uploading body artifact",
+			zap.String("artifact", upload.name),
+			zap.Error(err),
+		)
+		return ""
+	}
+	return u.store.Locate(upload.name)
+}
+
+// artifactName returns a unique, time-sortable object name
+func artifactName(now time.Time) string {
+	var suffix [8]byte
+	_, _ = rand.Read(suffix[:])
+	return fmt.Sprintf("%s-%s.bin", now.UTC().Format("20060102T150405.000000000Z"), hex.EncodeToString(suffix[:]))
+}
@@ -0,0 +1,57 @@
+package request_logger
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestArtifactsAreProtected(t *testing.T) {
+	dir := t.TempDir()
+	rl := parseTest(t, `request_logger {
+		include_request_body
+		artifact_store file://`+dir+`
+		deidentify_pipeline scrub_body_pii
+	}`)
+	provisionTest(t, rl)
+
+	r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(piiResponse))
+	r.Header.Set("Content-Type", "application/json")
+	serveTest(t, rl, r, http.StatusOK, "")
+	rl.artifacts.Close(time.Now().Add(time.Second))
+
+	names, _ := filepath.Glob(filepath.Join(dir, "*.bin"))
+	if len(names) != 1 {
+		t.Fatalf("got %d artifacts, want 1", len(names))
+	}
+	stored, err := os.ReadFile(names[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(stored), piiEmail) || strings.Contains(string(stored), piiCard) {
+		t.Errorf("artifact stored in the clear: %s", stored)
+	}
+}
+
+func TestArtifactEnqueueAfterClose(t *testing.T) {
+	dir := t.TempDir()
+	store, err := newArtifactStore("file://" + dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := newArtifactUploader(store, zap.NewNop())
+	u.Close(time.Now().Add(time.Second))
+
+	if ref := u.Enqueue([]byte("late")); ref != "" {
+		t.Errorf("closed uploader accepted a body as %s", ref)
+	}
+	if names, _ := filepath.Glob(filepath.Join(dir, "*.bin")); len(names) != 0 {
+		t.Errorf("closed uploader stored %d artifacts", len(names))
+	}
+}
@@ -0,0 +1,423 @@
+package request_logger
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// readChunkSize is the buffer size used when reading bodies in the background
+const readChunkSize = 32 * 1024
+
+// captureBody reads up to limit bytes of the request body for logging and
+// replaces r.Body so the downstream handler still receives the complete,
+// unmodified body. One byte past the limit is read to tell whether the body
+// was cut off, which is reported as truncated. If timeout is positive and
+// the client does not deliver the captured portion in time, the bytes read
+// so far are returned along with timedOut set; reading continues in the
+// background for the handler.
+func captureBody(r *http.Request, limit int64, timeout time.Duration) (captured []byte, truncated, timedOut bool) {
+	original := r.Body
+
+	if timeout <= 0 {
+		data, _ := io.ReadAll(io.LimitReader(original, limit+1))
+		r.Body = &replayBody{Reader: io.MultiReader(bytes.NewReader(data), original), Closer: original}
+		captured, truncated = capBody(data, limit)
+		return captured, truncated, false
+	}
+
+	tb := &timedBody{ready: make(chan struct{}, 1)}
+	go tb.fill(io.LimitReader(original, limit+1))
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	for {
+		data, done := tb.snapshot()
+		if done {
+			r.Body = &replayBody{Reader: io.MultiReader(bytes.NewReader(data), original), Closer: original}
+			captured, truncated = capBody(data, limit)
+			return captured, truncated, false
+		}
+
+		select {
+		case <-tb.ready:
+		case <-timer.C:
+			// Hand the in-flight read over to the handler, which picks up
+			// from the first byte and continues with the rest of the body
+			data, _ = tb.snapshot()
+			r.Body = &replayBody{Reader: io.MultiReader(tb, original), Closer: original}
+			captured, truncated = capBody(data, limit)
+			return captured, truncated, true
+		}
+	}
+}
+
+// capBody cuts data down to limit bytes and reports whether anything was cut
+func capBody(data []byte, limit int64) ([]byte, bool) {
+	if int64(len(data)) > limit {
+		return data[:limit], true
+	}
+	return data, false
+}
+
+// replayBody serves already captured bytes followed by the rest of the
+// original body and closes the original body
+type replayBody struct {
+	io.Reader
+	io.Closer
+}
+
+// timedBody accumulates a body read by a background goroutine. It never
+// blocks the reading goroutine, so the goroutine exits as soon as the
+// source is exhausted even if nobody consumes the buffered bytes.
+type timedBody struct {
+	mu    sync.Mutex
+	buf   []byte
+	off   int
+	done  bool
+	err   error
+	ready chan struct{}
+}
+
+// fill reads src until EOF or error, signalling ready after every read
+func (tb *timedBody) fill(src io.Reader) {
+	chunk := make([]byte, readChunkSize)
+	for {
+		n, err := src.Read(chunk)
+
+		tb.mu.Lock()
+		tb.buf = append(tb.buf, chunk[:n]...)
+		if err != nil {
+			tb.done = true
+			if err != io.EOF {
+				tb.err = err
+			}
+		}
+		tb.mu.Unlock()
+
+		select {
+		case tb.ready <- struct{}{}:
+		default:
+		}
+
+		if err != nil {
+			return
+		}
+	}
+}
+
+// snapshot returns a copy of the bytes read so far and whether reading
+// has finished
+func (tb *timedBody) snapshot() ([]byte, bool) {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+	return append([]byte(nil), tb.buf...), tb.done
+}
+
+// Read implements io.Reader, waiting for the background goroutine when
+// all buffered bytes have been consumed
+func (tb *timedBody) Read(p []byte) (int, error) {
+	for {
+		tb.mu.Lock()
+		if tb.off < len(tb.buf) {
+			n := copy(p, tb.buf[tb.off:])
+			tb.off += n
+			tb.mu.Unlock()
+			return n, nil
+		}
+		if tb.done {
+			err := tb.err
+			tb.mu.Unlock()
+			if err == nil {
+				err = io.EOF
+			}
+			return 0, err
+		}
+		tb.mu.Unlock()
+		<-tb.ready
+	}
+}
+
+// bodySampler passes a body through to the handler while keeping evenly
+// spaced samples of it: the head, the middle (when the total length is
+// known) and the tail. Only the bytes the handler actually reads are seen.
+type bodySampler struct {
+	src     io.ReadCloser
+	segment int64
+	total   int64
+	offset  int64
+
+	head   []byte
+	middle []byte
+	tail   []byte // ring buffer of the last segment bytes
+	tailAt int
+}
+
+func newBodySampler(src io.ReadCloser, sampleBytes int, total int64) *bodySampler {
+	segment := int64(sampleBytes / 3)
+	if segment < 1 {
+		segment = 1
+	}
+	return &bodySampler{
+		src:     src,
+		segment: segment,
+		total:   total,
+		tail:    make([]byte, 0, segment),
+	}
+}
+
+func (bs *bodySampler) Read(p []byte) (int, error) {
+	n, err := bs.src.Read(p)
+	bs.observe(p[:n])
+	return n, err
+}
+
+func (bs *bodySampler) Close() error {
+	return bs.src.Close()
+}
+
+// observe records the portions of chunk that fall into a sample window
+func (bs *bodySampler) observe(chunk []byte) {
+	start := bs.offset
+	bs.offset += int64(len(chunk))
+
+	// Head
+	if start < bs.segment {
+		end := min(bs.segment-start, int64(len(chunk)))
+		bs.head = append(bs.head, chunk[:end]...)
+	}
+
+	// Middle window centered on the known total length
+	if bs.total > 3*bs.segment {
+		midStart := bs.total/2 - bs.segment/2
+		midEnd := midStart + bs.segment
+		from := max(midStart, start) - start
+		to := min(midEnd, bs.offset) - start
+		if from < to {
+			bs.middle = append(bs.middle, chunk[from:to]...)
+		}
+	}
+
+	// Tail
+	for _, b := range chunk {
+		if int64(len(bs.tail)) < bs.segment {
+			bs.tail = append(bs.tail, b)
+			continue
+		}
+		bs.tail[bs.tailAt] = b
+		bs.tailAt = (bs.tailAt + 1) % len(bs.tail)
+	}
+}
+
+// Sample returns the concatenated samples in stream order. Samples that
+// are not contiguous are separated by a marker noting how many bytes were
+// skipped between them.
+func (bs *bodySampler) Sample() []byte {
+	type piece struct {
+		start int64
+		data  []byte
+	}
+	pieces := []piece{{start: 0, data: bs.head}}
+	if len(bs.middle) > 0 {
+		pieces = append(pieces, piece{start: bs.total/2 - bs.segment/2, data: bs.middle})
+	}
+	pieces = append(pieces, piece{start: bs.offset - int64(len(bs.tail)), data: bs.orderedTail()})
+
+	var out bytes.Buffer
+	var pos int64
+	for _, p := range pieces {
+		end := p.start + int64(len(p.data))
+		if end <= pos {
+			continue
+		}
+		if p.start > pos {
+			fmt.Fprintf(&out, "\n...[%d bytes skipped]...\n", p.start-pos)
+			out.Write(p.data)
+		} else {
+			// Overlaps what was already written
+			out.Write(p.data[pos-p.start:])
+		}
+		pos = end
+	}
+	return out.Bytes()
+}
+
+// orderedTail returns the tail ring buffer in stream order
+func (bs *bodySampler) orderedTail() []byte {
+	return append(append([]byte(nil), bs.tail[bs.tailAt:]...), bs.tail[:bs.tailAt]...)
+}
+
+// minPrintableRatio is the share of printable characters above which a body
+// is treated as text by auto_body_encoding
+const minPrintableRatio = 0.95
+
+// isTextBody reports whether body looks like text: valid UTF-8 where nearly
+// all characters are printable or common whitespace. A sequence cut off at
+// the end of a truncated body is not held against it.
+func isTextBody(body []byte) bool {
+	if len(body) == 0 {
+		return true
+	}
+
+	printable, total := 0, 0
+	for i := 0; i < len(body); {
+		r, size := utf8.DecodeRune(body[i:])
+		i += size
+		total++
+		switch {
+		case r == utf8.RuneError && size == 1:
+			if !utf8.FullRune(body[i-1:]) {
+				total--
+				continue
+			}
+		case r == '\t' || r == '\n' || r == '\r' || unicode.IsPrint(r):
+			printable++
+		}
+	}
+	return total == 0 || float64(printable)/float64(total) >= minPrintableRatio
+}
+
+// isTextContentType reports whether the content type is a line-oriented
+// text format
+func isTextContentType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	switch {
+	case strings.HasPrefix(mediaType, "text/"):
+		return true
+	case strings.HasSuffix(mediaType, "+json"), strings.HasSuffix(mediaType, "+xml"):
+		return true
+	}
+	switch mediaType {
+	case "application/json", "application/x-ndjson", "application/xml",
+		"application/javascript", "application/x-www-form-urlencoded":
+		return true
+	}
+	return false
+}
+
+// truncateLines keeps the first maxLines lines of body and replaces the rest
+// with a marker stating how many lines were dropped
+func truncateLines(body []byte, maxLines int) []byte {
+	end := 0
+	for i := 0; i < maxLines; i++ {
+		next := bytes.IndexByte(body[end:], '\n')
+		if next < 0 {
+			return body
+		}
+		end += next + 1
+	}
+	if end >= len(body) {
+		return body
+	}
+
+	rest := body[end:]
+	dropped := bytes.Count(rest, []byte{'\n'})
+	if rest[len(rest)-1] != '\n' {
+		dropped++
+	}
+
+	truncated := make([]byte, 0, end+32)
+	truncated = append(truncated, body[:end]...)
+	return fmt.Appendf(truncated, "...(%d more lines)", dropped)
+}
+
+// stallReader measures how long the handler leaves the body unread between
+// two reads while more of it remains. Time spent inside Read is the client
+// delivering data; time between reads is the handler (or the upstream it
+// proxies to) not consuming it. Gaps are only seen while the handler reads
+// in chunks: a handler that stops reading entirely or buffers the whole body
+// in one call shows no gap, and a backend pausing after the last byte is
+// not counted.
+type stallReader struct {
+	io.ReadCloser
+	mu       sync.Mutex
+	lastRead time.Time
+	maxGap   time.Duration
+	done     bool
+}
+
+func (sr *stallReader) Read(p []byte) (int, error) {
+	sr.mu.Lock()
+	if !sr.lastRead.IsZero() && !sr.done {
+		sr.maxGap = max(sr.maxGap, time.Since(sr.lastRead))
+	}
+	sr.mu.Unlock()
+
+	n, err := sr.ReadCloser.Read(p)
+
+	sr.mu.Lock()
+	sr.lastRead = time.Now()
+	sr.done = err != nil
+	sr.mu.Unlock()
+	return n, err
+}
+
+// MaxGap returns the longest pause between two reads of the body
+func (sr *stallReader) MaxGap() time.Duration {
+	sr.mu.Lock()
+	defer sr.mu.Unlock()
+	return sr.maxGap
+}
+
+// Digest algorithms for hash_body
+const (
+	hashSHA256 = "sha256"
+	hashMD5    = "md5"
+)
+
+// newBodyHash returns a hash for a hash_body algorithm, defaulting to
+// SHA-256
+func newBodyHash(algorithm string) hash.Hash {
+	if algorithm == hashMD5 {
+		return md5.New()
+	}
+	return sha256.New()
+}
+
+// hashingReader feeds everything the handler reads from the body through a
+// hash, so the whole body is digested without being held in memory
+type hashingReader struct {
+	io.ReadCloser
+	mu   sync.Mutex
+	hash hash.Hash
+	eof  bool
+}
+
+func newHashingReader(body io.ReadCloser, algorithm string) *hashingReader {
+	return &hashingReader{ReadCloser: body, hash: newBodyHash(algorithm)}
+}
+
+func (hr *hashingReader) Read(p []byte) (int, error) {
+	n, err := hr.ReadCloser.Read(p)
+
+	hr.mu.Lock()
+	hr.hash.Write(p[:n])
+	if err == io.EOF {
+		hr.eof = true
+	}
+	hr.mu.Unlock()
+	return n, err
+}
+
+// Sum returns the hex digest of the body and whether the handler read it
+// to the end; otherwise the digest only covers the bytes read
+func (hr *hashingReader) Sum() (string, bool) {
+	hr.mu.Lock()
+	defer hr.mu.Unlock()
+	return hex.EncodeToString(hr.hash.Sum(nil)), hr.eof
+}
@@ -0,0 +1,39 @@
+package request_logger
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// defaultCacheKeyTemplate mirrors the key most HTTP caches use
+const defaultCacheKeyTemplate = "{method} {host}{path}?{query} {vary}"
+
+// cacheKey assembles the cache key template for r and returns it along with
+// its SHA-256 hash. The template understands {method}, {host}, {path} (the
+// cleaned path), {query} (parameters sorted by name, so reordered queries
+// produce the same key) and {vary} (the values of varyHeaders).
+func cacheKey(r *http.Request, template string, varyHeaders []string) (string, string) {
+	cleanPath := r.URL.Path
+	if cleanPath != "" {
+		cleanPath = path.Clean(cleanPath)
+	}
+
+	var vary []string
+	for _, name := range varyHeaders {
+		vary = append(vary, strings.ToLower(name)+"="+strings.Join(r.Header.Values(name), ","))
+	}
+
+	key := strings.NewReplacer(
+		"{method}", r.Method,
+		"{host}", strings.ToLower(r.Host),
+		"{path}", cleanPath,
+		"{query}", r.URL.Query().Encode(),
+		"{vary}", strings.Join(vary, "&"),
+	).Replace(template)
+
+	sum := sha256.Sum256([]byte(key))
+	return key, hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,63 @@
+package request_logger
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+
+	"go.uber.org/zap/zapcore"
+)
+
+// outputFormatCaddy lays out fields like Caddy's native access logs
+const outputFormatCaddy = "caddy"
+
+// caddyRequest marshals a request with the same field layout as Caddy's
+// access log "request" object. ip, clientIP and uri are the remote IP,
+// originating client IP and request URI as they should be logged.
+type caddyRequest struct {
+	r        *http.Request
+	ip       string
+	clientIP string
+	uri      string
+	headers  any
+}
+
+func (cr caddyRequest) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	_, port, err := net.SplitHostPort(cr.r.RemoteAddr)
+	if err != nil {
+		port = ""
+	}
+
+	enc.AddString("remote_ip", cr.ip)
+	enc.AddString("remote_port", port)
+	enc.AddString("client_ip", cr.clientIP)
+	enc.AddString("proto", cr.r.Proto)
+	enc.AddString("method", cr.r.Method)
+	enc.AddString("host", cr.r.Host)
+	enc.AddString("uri", cr.uri)
+	if cr.headers != nil {
+		if err := enc.AddReflected("headers", cr.headers); err != nil {
+			return err
+		}
+	}
+	if cr.r.TLS != nil {
+		if err := enc.AddObject("tls", caddyTLS{cr.r.TLS}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// caddyTLS marshals TLS connection state like Caddy's access log
+type caddyTLS struct {
+	state *tls.ConnectionState
+}
+
+func (ct caddyTLS) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	enc.AddBool("resumed", ct.state.DidResume)
+	enc.AddUint16("version", ct.state.Version)
+	enc.AddUint16("cipher_suite", ct.state.CipherSuite)
+	enc.AddString("proto", ct.state.NegotiatedProtocol)
+	enc.AddString("server_name", ct.state.ServerName)
+	return nil
+}
@@ -0,0 +1,142 @@
+package request_logger
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// maxCoalescedEntries bounds the number of distinct signatures buffered per
+// window; entries beyond it are written immediately
+const maxCoalescedEntries = 10000
+
+// coalescer buffers log entries per request signature and writes one entry
+// per signature at the end of every window, with a count of how many
+// identical requests it stands for
+type coalescer struct {
+	window time.Duration
+
+	mu      sync.Mutex
+	pending map[string]*coalescedEntry
+	closed  bool
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+type coalescedEntry struct {
+	logger  *zap.Logger
+	level   zapcore.Level
+	message string
+	fields  []zap.Field
+	count   int
+}
+
+func newCoalescer(window time.Duration) *coalescer {
+	c := &coalescer{
+		window:  window,
+		pending: make(map[string]*coalescedEntry),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	go c.run()
+	return c
+}
+
+// requestSignature identifies requests that are considered identical: the
+// same request with the same response status and error class, so a failed
+// request is never folded into a successful one
+func requestSignature(r *http.Request, status int, errClass string) string {
+	return strings.Join([]string{
+		r.Method,
+		r.Host,
+		r.URL.Path,
+		r.URL.RawQuery,
+		remoteIP(r),
+		r.UserAgent(),
+		strconv.Itoa(status),
+		errClass,
+	}, "\x00")
+}
+
+// Add buffers an entry for logger, merging it with a pending entry of the
+// same signature. The fields of the first entry in a window are kept.
+// Entries added after Close are written immediately.
+func (c *coalescer) Add(logger *zap.Logger, signature string, level zapcore.Level, message string, fields []zap.Field) {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		c.write(&coalescedEntry{logger: logger, level: level, message: message, fields: fields, count: 1})
+		return
+	}
+	if entry, ok := c.pending[signature]; ok {
+		entry.count++
+		if level > entry.level {
+			entry.level = level
+		}
+		c.mu.Unlock()
+		return
+	}
+	if len(c.pending) < maxCoalescedEntries {
+		c.pending[signature] = &coalescedEntry{logger: logger, level: level, message: message, fields: fields, count: 1}
+		c.mu.Unlock()
+		return
+	}
+	c.mu.Unlock()
+
+	c.write(&coalescedEntry{logger: logger, level: level, message: message, fields: fields, count: 1})
+}
+
+// Close stops the flush loop and writes all pending entries
+func (c *coalescer) Close() {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return
+	}
+	c.closed = true
+	c.mu.Unlock()
+
+	close(c.stop)
+	<-c.done
+}
+
+func (c *coalescer) run() {
+	defer close(c.done)
+
+	ticker := time.NewTicker(c.window)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			c.flush()
+		case <-c.stop:
+			c.flush()
+			return
+		}
+	}
+}
+
+// flush writes and clears all pending entries
+func (c *coalescer) flush() {
+	c.mu.Lock()
+	pending := c.pending
+	c.pending = make(map[string]*coalescedEntry, len(pending))
+	c.mu.Unlock()
+
+	for _, entry := range pending {
+		c.write(entry)
+	}
+}
+
+func (c *coalescer) write(entry *coalescedEntry) {
+	if ce := entry.logger.Check(entry.level, entry.message); ce != nil {
+		ce.Write(append(entry.fields, zap.Int("count", entry.count))...)
+	}
+}
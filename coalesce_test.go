@@ -0,0 +1,58 @@
+package request_logger
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCoalesceMergesBursts(t *testing.T) {
+	rl := parseTest(t, `request_logger {
+		coalesce_window 1h
+	}`)
+	logs := provisionTest(t, rl)
+	for i := 0; i < 5; i++ {
+		serveTest(t, rl, httptest.NewRequest(http.MethodGet, "/retry", nil), http.StatusOK, "")
+	}
+	serveTest(t, rl, httptest.NewRequest(http.MethodGet, "/other", nil), http.StatusOK, "")
+	if n := logs.Len(); n != 0 {
+		t.Fatalf("%d entries written before the window ended", n)
+	}
+	rl.coalescer.Close()
+
+	counts := make(map[string]int64)
+	for _, entry := range logs.All() {
+		fields := entry.ContextMap()
+		path, _ := fields["path"].(string)
+		counts[path], _ = fields["count"].(int64)
+	}
+	if logs.Len() != 2 || counts["/retry"] != 5 || counts["/other"] != 1 {
+		t.Errorf("coalesced counts by path = %v, want 5 for /retry and 1 for /other", counts)
+	}
+}
+
+func TestCoalesceKeepsFailuresApart(t *testing.T) {
+	rl := parseTest(t, `request_logger {
+		coalesce_window 1h
+		include_response
+	}`)
+	logs := provisionTest(t, rl)
+	for _, status := range []int{200, 200, 500, 200, 500} {
+		serveTest(t, rl, httptest.NewRequest(http.MethodGet, "/orders", nil), status, "")
+	}
+	rl.coalescer.Close()
+
+	if n := logs.Len(); n != 2 {
+		t.Fatalf("got %d coalesced entries, want one per status", n)
+	}
+	counts := make(map[int64]int64)
+	for _, entry := range logs.All() {
+		fields := entry.ContextMap()
+		status, _ := fields["status"].(int64)
+		count, _ := fields["count"].(int64)
+		counts[status] += count
+	}
+	if counts[200] != 3 || counts[500] != 2 {
+		t.Errorf("coalesced counts by status = %v, want 3 for 200 and 2 for 500", counts)
+	}
+}
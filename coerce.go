@@ -0,0 +1,118 @@
+package request_logger
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// validateFieldTypes checks the target types of field_types
+func validateFieldTypes(types map[string]string) error {
+	for field, typ := range types {
+		switch typ {
+		case "string", "int", "float", "bool":
+		default:
+			return fmt.Errorf("invalid field_types type %q for %s (expected string, int, float or bool)", typ, field)
+		}
+	}
+	return nil
+}
+
+// coerceFields converts the fields named in types to the requested type.
+// Fields that cannot be converted are left unchanged and reported through
+// warn.
+func coerceFields(fields []zap.Field, types map[string]string, warn func(field, typ string)) {
+	for i, f := range fields {
+		typ, ok := types[f.Key]
+		if !ok {
+			continue
+		}
+		if coerced, ok := coerceField(f, typ); ok {
+			fields[i] = coerced
+		} else {
+			warn(f.Key, typ)
+		}
+	}
+}
+
+// coerceField returns f converted to typ
+func coerceField(f zap.Field, typ string) (zap.Field, bool) {
+	value, ok := scalarValue(f)
+	if !ok {
+		return f, false
+	}
+
+	switch typ {
+	case "string":
+		return zap.String(f.Key, fmt.Sprint(value)), true
+	case "int":
+		switch v := value.(type) {
+		case int64:
+			return zap.Int64(f.Key, v), true
+		case float64:
+			if v != math.Trunc(v) || math.IsInf(v, 0) || math.IsNaN(v) {
+				return f, false
+			}
+			return zap.Int64(f.Key, int64(v)), true
+		case bool:
+			if v {
+				return zap.Int64(f.Key, 1), true
+			}
+			return zap.Int64(f.Key, 0), true
+		case time.Duration:
+			return zap.Int64(f.Key, int64(v)), true
+		case string:
+			n, err := strconv.ParseInt(v, 10, 64)
+			return zap.Int64(f.Key, n), err == nil
+		}
+	case "float":
+		switch v := value.(type) {
+		case int64:
+			return zap.Float64(f.Key, float64(v)), true
+		case float64:
+			return zap.Float64(f.Key, v), true
+		case time.Duration:
+			return zap.Float64(f.Key, v.Seconds()), true
+		case string:
+			n, err := strconv.ParseFloat(v, 64)
+			return zap.Float64(f.Key, n), err == nil
+		}
+	case "bool":
+		switch v := value.(type) {
+		case int64:
+			return zap.Bool(f.Key, v != 0), true
+		case bool:
+			return zap.Bool(f.Key, v), true
+		case string:
+			b, err := strconv.ParseBool(v)
+			return zap.Bool(f.Key, b), err == nil
+		}
+	}
+	return f, false
+}
+
+// scalarValue returns the value of a string, numeric, bool or duration
+// field; other field types are not convertible
+func scalarValue(f zap.Field) (any, bool) {
+	switch f.Type {
+	case zapcore.StringType:
+		return f.String, true
+	case zapcore.ByteStringType:
+		b, ok := f.Interface.([]byte)
+		return string(b), ok
+	case zapcore.Int64Type, zapcore.Int32Type, zapcore.Int16Type, zapcore.Int8Type,
+		zapcore.Uint64Type, zapcore.Uint32Type, zapcore.Uint16Type, zapcore.Uint8Type:
+		return f.Integer, true
+	case zapcore.Float64Type:
+		return math.Float64frombits(uint64(f.Integer)), true
+	case zapcore.BoolType:
+		return f.Integer == 1, true
+	case zapcore.DurationType:
+		return time.Duration(f.Integer), true
+	}
+	return nil, false
+}
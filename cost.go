@@ -0,0 +1,61 @@
+package request_logger
+
+import (
+	"fmt"
+	pathpkg "path"
+	"strings"
+)
+
+// CostRule adds to the cost of requests it matches. The logged
+// request_cost is the sum over all matching rules of Cost plus PerKB times
+// the body size in kilobytes.
+type CostRule struct {
+	// HTTP method to match, or * (or empty) for any
+	Method string `json:"method,omitempty"`
+
+	// Path pattern to match (a prefix ending in * or a glob), or * (or
+	// empty) for any
+	Path string `json:"path,omitempty"`
+
+	// Fixed cost added for a matching request
+	Cost float64 `json:"cost,omitempty"`
+
+	// Cost added per kilobyte of request body
+	PerKB float64 `json:"per_kb,omitempty"`
+}
+
+// matches reports whether the rule applies to a request
+func (cr CostRule) matches(method, path string) bool {
+	if cr.Method != "" && cr.Method != "*" && !strings.EqualFold(cr.Method, method) {
+		return false
+	}
+	return cr.Path == "" || cr.Path == "*" || matchPathPattern(cr.Path, path)
+}
+
+// validateCostRules checks that every path pattern is well-formed and no
+// cost is negative
+func validateCostRules(rules []CostRule) error {
+	for _, rule := range rules {
+		if rule.Path != "" && rule.Path != "*" {
+			if _, err := pathpkg.Match(rule.Path, "/"); err != nil {
+				return fmt.Errorf("invalid request_cost path %q: %v", rule.Path, err)
+			}
+		}
+		if rule.Cost < 0 || rule.PerKB < 0 {
+			return fmt.Errorf("request_cost for %s %s must not be negative", rule.Method, rule.Path)
+		}
+	}
+	return nil
+}
+
+// requestCost sums the costs of the rules matching a request with a body
+// of bodySize bytes
+func requestCost(rules []CostRule, method, path string, bodySize int64) float64 {
+	var cost float64
+	for _, rule := range rules {
+		if rule.matches(method, path) {
+			cost += rule.Cost + rule.PerKB*float64(bodySize)/1024
+		}
+	}
+	return cost
+}
@@ -0,0 +1,90 @@
+package request_logger
+
+import (
+	"sync"
+	"time"
+)
+
+// windowCounter counts events per key within a fixed time window that starts
+// at the first event for that key. Keys idle for longer than the window are
+// swept periodically and the number of tracked keys is capped, so memory
+// stays bounded regardless of how many distinct keys are seen.
+//
+// An idle counter instead keeps counting for as long as the key is seen at
+// least once per window, which suits long-lived keys such as connections.
+type windowCounter struct {
+	mu        sync.Mutex
+	window    time.Duration
+	idle      bool
+	maxKeys   int
+	entries   map[string]*windowEntry
+	lastSweep time.Time
+}
+
+type windowEntry struct {
+	start    time.Time
+	lastSeen time.Time
+	count    int
+}
+
+func newWindowCounter(window time.Duration, maxKeys int) *windowCounter {
+	return &windowCounter{
+		window:    window,
+		maxKeys:   maxKeys,
+		entries:   make(map[string]*windowEntry),
+		lastSweep: time.Now(),
+	}
+}
+
+// newIdleCounter returns a counter whose count for a key only resets after
+// the key has been idle for a full window
+func newIdleCounter(window time.Duration, maxKeys int) *windowCounter {
+	c := newWindowCounter(window, maxKeys)
+	c.idle = true
+	return c
+}
+
+// Increment records an event for key and returns the number of events
+// seen for key in the current window, including this one
+func (c *windowCounter) Increment(key string, now time.Time) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if now.Sub(c.lastSweep) >= c.window {
+		c.sweep(now)
+	}
+
+	entry, ok := c.entries[key]
+	if !ok {
+		if len(c.entries) >= c.maxKeys {
+			c.evictOne()
+		}
+		entry = &windowEntry{start: now}
+		c.entries[key] = entry
+	} else if (!c.idle && now.Sub(entry.start) >= c.window) || now.Sub(entry.lastSeen) >= c.window {
+		entry.start = now
+		entry.count = 0
+	}
+
+	entry.count++
+	entry.lastSeen = now
+	return entry.count
+}
+
+// sweep removes entries that have not been seen for a full window
+func (c *windowCounter) sweep(now time.Time) {
+	for key, entry := range c.entries {
+		if now.Sub(entry.lastSeen) >= c.window {
+			delete(c.entries, key)
+		}
+	}
+	c.lastSweep = now
+}
+
+// evictOne drops an arbitrary entry to make room for a new key
+func (c *windowCounter) evictOne() {
+	for key := range c.entries {
+		delete(c.entries, key)
+		return
+	}
+}
@@ -0,0 +1,56 @@
+package request_logger
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// requestTimeoutHeader carries the client's timeout for the request,
+	// as a duration such as 2.5s or a number of seconds
+	requestTimeoutHeader = "X-Request-Timeout"
+
+	// deadlineNearMiss is the fraction of the time budget below which a
+	// remaining margin counts as a near miss
+	deadlineNearMiss = 0.1
+)
+
+// requestDeadline returns the request's deadline and the time budget it
+// allowed from start. The client's X-Request-Timeout header takes
+// precedence over a deadline on the request context.
+func requestDeadline(r *http.Request, start time.Time) (time.Time, time.Duration, bool) {
+	if value := r.Header.Get(requestTimeoutHeader); value != "" {
+		timeout, err := time.ParseDuration(value)
+		if err != nil {
+			seconds, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return time.Time{}, 0, false
+			}
+			timeout = time.Duration(seconds * float64(time.Second))
+		}
+		if timeout > 0 {
+			return start.Add(timeout), timeout, true
+		}
+	}
+	if deadline, ok := r.Context().Deadline(); ok {
+		return deadline, deadline.Sub(start), true
+	}
+	return time.Time{}, 0, false
+}
+
+// deadlineFields returns the margin left before the deadline at now and
+// flags deadlines that were exceeded or nearly exceeded
+func deadlineFields(deadline time.Time, budget time.Duration, now time.Time) []zap.Field {
+	margin := deadline.Sub(now)
+	fields := []zap.Field{zap.Duration("deadline_margin", margin)}
+	switch {
+	case margin < 0:
+		fields = append(fields, zap.Bool("deadline_exceeded", true))
+	case float64(margin) < deadlineNearMiss*float64(budget):
+		fields = append(fields, zap.Bool("deadline_near_miss", true))
+	}
+	return fields
+}
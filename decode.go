@@ -0,0 +1,51 @@
+package request_logger
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+)
+
+// decodeContentEncoding decompresses a captured body sent with the given
+// Content-Encoding (gzip, deflate or br) for logging. At most limit bytes
+// are decompressed, so a decompression bomb cannot exhaust memory, and
+// truncated reports whether the output was cut off. A body cut off at
+// max_body_size decodes up to where it ends. It reports false if the
+// encoding is not supported or nothing could be decoded.
+func decodeContentEncoding(body []byte, encoding string, limit int) (decoded []byte, truncated, ok bool) {
+	var rd io.Reader
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "gzip", "x-gzip":
+		zr, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			return nil, false, false
+		}
+		rd = zr
+	case "deflate":
+		// deflate is zlib-wrapped per RFC 9110, but some clients send raw
+		// deflate data
+		if zr, err := zlib.NewReader(bytes.NewReader(body)); err == nil {
+			rd = zr
+		} else {
+			rd = flate.NewReader(bytes.NewReader(body))
+		}
+	case "br":
+		rd = brotli.NewReader(bytes.NewReader(body))
+	default:
+		return nil, false, false
+	}
+
+	decoded, _ = io.ReadAll(io.LimitReader(rd, int64(limit)+1))
+	if len(decoded) == 0 {
+		return nil, false, false
+	}
+	if len(decoded) > limit {
+		return decoded[:limit], true, true
+	}
+	return decoded, false, true
+}
@@ -0,0 +1,261 @@
+package request_logger
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// redactedValue replaces redacted header values
+const redactedValue = "***REDACTED***"
+
+// sensitiveHeaders are masked by the redact_headers de-identification step
+var sensitiveHeaders = []string{
+	"Authorization",
+	"Proxy-Authorization",
+	"Cookie",
+	"Set-Cookie",
+	"X-Api-Key",
+	"X-Auth-Token",
+}
+
+// deidentifySteps are the transformations deidentify_pipeline can run over
+// the assembled log fields
+var deidentifySteps = map[string]func(fields []zap.Field){
+	"anonymize_ip":   anonymizeIPFields,
+	"redact_headers": redactHeaderFields,
+	"scrub_body_pii": scrubBodyFields,
+	"clean_query":    cleanQueryFields,
+}
+
+// validateDeidentifyPipeline checks that every step name is known
+func validateDeidentifyPipeline(steps []string) error {
+	for _, step := range steps {
+		if _, ok := deidentifySteps[step]; !ok {
+			return fmt.Errorf("unknown deidentify_pipeline step %q (expected anonymize_ip, redact_headers, scrub_body_pii or clean_query)", step)
+		}
+	}
+	return nil
+}
+
+// deidentify runs the steps over fields in order
+func deidentify(steps []string, fields []zap.Field) {
+	for _, step := range steps {
+		deidentifySteps[step](fields)
+	}
+}
+
+// rewriteCaddyRequest applies fn to the request object of the caddy output
+// format, if fields has one
+func rewriteCaddyRequest(fields []zap.Field, fn func(cr *caddyRequest)) {
+	for i := range fields {
+		f := &fields[i]
+		if f.Type != zapcore.ObjectMarshalerType {
+			continue
+		}
+		if cr, ok := f.Interface.(caddyRequest); ok {
+			fn(&cr)
+			f.Interface = cr
+		}
+	}
+}
+
+// anonymizeIPFields masks every logged client address to /24 (IPv4) or
+// /48 (IPv6)
+func anonymizeIPFields(fields []zap.Field) {
+	rewriteFields(fields, keySet("remote_addr", "client_ip"), func(_, value string) string {
+		return mapAddrHost(value, anonymizeIP)
+	})
+	for _, f := range fields {
+		if hops, ok := f.Interface.([]map[string]string); ok && f.Key == "forwarded" {
+			maskForwardedFor(hops, anonymizeIP)
+		}
+	}
+	rewriteCaddyRequest(fields, func(cr *caddyRequest) {
+		cr.ip = anonymizeIP(cr.ip)
+		cr.clientIP = anonymizeIP(cr.clientIP)
+	})
+}
+
+// redactHeaderFields masks the values of sensitive request and response
+// headers while keeping the header names visible
+func redactHeaderFields(fields []zap.Field) {
+	for i := range fields {
+		f := &fields[i]
+		if f.Key != "headers" && f.Key != "resp_headers" {
+			continue
+		}
+		switch f.Type {
+		case zapcore.ReflectType:
+			f.Interface = redactHeaders(f.Interface, sensitiveHeaders)
+		case zapcore.StringType:
+			f.String = redactHeaders(f.String, sensitiveHeaders).(string)
+		}
+	}
+	rewriteCaddyRequest(fields, func(cr *caddyRequest) {
+		cr.headers = redactHeaders(cr.headers, sensitiveHeaders)
+	})
+}
+
+// redactHeaders returns a copy of collected headers with the values of the
+// named headers masked
+func redactHeaders(headers any, names []string) any {
+	return mapHeaders(headers, func(name, value string) (string, bool) {
+		for _, n := range names {
+			if strings.EqualFold(n, name) {
+				return redactedValue, true
+			}
+		}
+		return value, true
+	})
+}
+
+// mapHeaders returns a copy of collected headers with every value replaced
+// by the result of fn; headers for which fn returns false are dropped. It
+// understands the map forms collectHeaders returns and the JSON string form
+// of stable_header_order.
+func mapHeaders(headers any, fn func(name, value string) (string, bool)) any {
+	mapValues := func(name string, values []string) ([]string, bool) {
+		mapped := make([]string, len(values))
+		for i, value := range values {
+			var keep bool
+			if mapped[i], keep = fn(name, value); !keep {
+				return nil, false
+			}
+		}
+		return mapped, true
+	}
+
+	switch h := headers.(type) {
+	case map[string][]string:
+		mapped := make(map[string][]string, len(h))
+		for name, values := range h {
+			if values, ok := mapValues(name, values); ok {
+				mapped[name] = values
+			}
+		}
+		return mapped
+	case http.Header:
+		return http.Header(mapHeaders(map[string][]string(h), fn).(map[string][]string))
+	case map[string]string:
+		mapped := make(map[string]string, len(h))
+		for name, value := range h {
+			if value, ok := fn(name, value); ok {
+				mapped[name] = value
+			}
+		}
+		return mapped
+	case string:
+		var decoded map[string]any
+		if err := json.Unmarshal([]byte(h), &decoded); err != nil {
+			return h
+		}
+		for name, value := range decoded {
+			keep := true
+			switch v := value.(type) {
+			case []any:
+				for i := range v {
+					s, _ := v[i].(string)
+					if v[i], keep = fn(name, s); !keep {
+						break
+					}
+				}
+			case string:
+				decoded[name], keep = fn(name, v)
+			}
+			if !keep {
+				delete(decoded, name)
+			}
+		}
+		encoded, err := json.Marshal(decoded)
+		if err != nil {
+			return h
+		}
+		return string(encoded)
+	}
+	return headers
+}
+
+// scrubBodyFields replaces emails, card and phone numbers in logged bodies
+// with a marker naming the kind of data removed, e.g. [email]
+func scrubBodyFields(fields []zap.Field) {
+	rewriteBodyFields(fields, nil, func(_, value string) string {
+		return scrubPII(value)
+	})
+}
+
+// scrubPII replaces emails, card and phone numbers in value with a marker
+// naming the kind of data removed
+func scrubPII(value string) string {
+	for _, p := range piiPatterns {
+		value = p.re.ReplaceAllString(value, "["+p.kind+"]")
+	}
+	return value
+}
+
+// cleanQueryFields drops query parameter values, keeping only their names
+func cleanQueryFields(fields []zap.Field) {
+	rewriteFields(fields, keySet("query"), func(_, value string) string {
+		return cleanQuery(value)
+	})
+	rewriteCaddyRequest(fields, func(cr *caddyRequest) {
+		if path, query, ok := strings.Cut(cr.uri, "?"); ok {
+			cr.uri = path + "?" + cleanQuery(query)
+		}
+	})
+}
+
+// cleanQuery strips the values from a raw query string, so a=1&b=2 becomes
+// a=&b=
+func cleanQuery(rawQuery string) string {
+	if rawQuery == "" {
+		return ""
+	}
+	params := strings.Split(rawQuery, "&")
+	for i, param := range params {
+		if name, _, ok := strings.Cut(param, "="); ok {
+			params[i] = name + "="
+		}
+	}
+	return strings.Join(params, "&")
+}
+
+// redactQuery masks the values of the named parameters in a raw query
+// string, keeping parameter order and repeated keys. Names are compared
+// after unescaping.
+func redactQuery(rawQuery string, names []string) string {
+	if rawQuery == "" || len(names) == 0 {
+		return rawQuery
+	}
+	params := strings.Split(rawQuery, "&")
+	for i, param := range params {
+		name, _, ok := strings.Cut(param, "=")
+		if !ok {
+			continue
+		}
+		unescaped, err := url.QueryUnescape(name)
+		if err != nil {
+			unescaped = name
+		}
+		for _, n := range names {
+			if unescaped == n {
+				params[i] = name + "=***"
+				break
+			}
+		}
+	}
+	return strings.Join(params, "&")
+}
+
+// redactURIQuery applies redactQuery to the query of a request URI
+func redactURIQuery(uri string, names []string) string {
+	if path, query, ok := strings.Cut(uri, "?"); ok {
+		return path + "?" + redactQuery(query, names)
+	}
+	return uri
+}
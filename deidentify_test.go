@@ -0,0 +1,89 @@
+package request_logger
+
+import (
+	"bytes"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const (
+	piiEmail = "alice@example.com"
+	piiCard  = "4111 1111 1111 1111"
+)
+
+// piiResponse is a response body carrying an email and a card number
+var piiResponse = `{"email":"` + piiEmail + `","card":"` + piiCard + `"}`
+
+// testRedactor masks the test email and card number
+type testRedactor struct{}
+
+func (testRedactor) Redact(_ string, value []byte) []byte {
+	value = bytes.ReplaceAll(value, []byte(piiEmail), []byte("[redacted]"))
+	return bytes.ReplaceAll(value, []byte(piiCard), []byte("[redacted]"))
+}
+
+func init() {
+	RegisterRedactor("test", testRedactor{})
+}
+
+// loggedBodies serves a request with a JSON body and a response carrying
+// PII through a logger configured with options, and returns the logged
+// request and response bodies, base64 decoded where needed
+func loggedBodies(t *testing.T, options string) (request, response string) {
+	t.Helper()
+	rl := parseTest(t, "request_logger {\n"+options+"\ninclude_request_body\ninclude_response_body\n}")
+	logs := provisionTest(t, rl)
+
+	r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(piiResponse))
+	r.Header.Set("Content-Type", "application/json")
+	serveTest(t, rl, r, http.StatusOK, piiResponse)
+
+	entries := logs.All()
+	if len(entries) != 1 {
+		t.Fatalf("got %d entries, want 1", len(entries))
+	}
+	fields := entries[0].ContextMap()
+	body := func(key string) string {
+		if v, ok := fields[key].(string); ok {
+			return v
+		}
+		v, _ := fields[key+"_b64"].(string)
+		decoded, err := base64.StdEncoding.DecodeString(v)
+		if err != nil {
+			t.Fatalf("%s_b64 is not base64: %v", key, err)
+		}
+		return string(decoded)
+	}
+	request, response = body("request_body"), body("response_body")
+	if request == "" || response == "" {
+		t.Fatalf("bodies not logged: %v", fields)
+	}
+	return request, response
+}
+
+func TestBodyPIIControlsCoverAllBodyFields(t *testing.T) {
+	tests := []struct {
+		name    string
+		options string
+	}{
+		{"scrub_body_pii", "deidentify_pipeline scrub_body_pii"},
+		{"scrub_body_pii base64", "deidentify_pipeline scrub_body_pii\nbase64_encode_body"},
+		{"tokenize_pii", "tokenize_pii\npii_key secret"},
+		{"tokenize_pii base64", "tokenize_pii\npii_key secret\nbase64_encode_body"},
+		{"redactor", "redactor test"},
+		{"redactor base64", "redactor test\nbase64_encode_body"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request, response := loggedBodies(t, tt.options)
+			for name, body := range map[string]string{"request": request, "response": response} {
+				if strings.Contains(body, piiEmail) || strings.Contains(body, piiCard) {
+					t.Errorf("%s body logged in the clear: %s", name, body)
+				}
+			}
+		})
+	}
+}
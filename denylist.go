@@ -0,0 +1,29 @@
+package request_logger
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+)
+
+// requestBodySignature identifies a request by method, path and the captured
+// body: the hex SHA-256 of the three joined by NUL bytes. Bodies are only
+// considered up to max_body_size.
+func requestBodySignature(method, path string, body []byte) string {
+	h := sha256.New()
+	h.Write([]byte(method))
+	h.Write([]byte{0})
+	h.Write([]byte(path))
+	h.Write([]byte{0})
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// newSignatureSet builds a lookup set of signatures, ignoring case
+func newSignatureSet(signatures []string) map[string]struct{} {
+	set := make(map[string]struct{}, len(signatures))
+	for _, sig := range signatures {
+		set[strings.ToLower(sig)] = struct{}{}
+	}
+	return set
+}
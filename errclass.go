@@ -0,0 +1,47 @@
+package request_logger
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+)
+
+// Error classes logged by classify_errors
+const (
+	errorClassTimeout     = "timeout"
+	errorClassAuthFailure = "auth_failure"
+	errorClassClient      = "client_error"
+	errorClassServer      = "server_error"
+)
+
+// classifyError maps the outcome of a request to an error class, or "" if
+// it succeeded. Rules are checked in order:
+//
+//   - timeout: the handler error is a deadline or network timeout, the
+//     status is 408 or 504, or the response came after the client's
+//     deadline
+//   - auth_failure: the status is 401, 403 or 407
+//   - client_error: the client went away (context canceled) or any other
+//     4xx status
+//   - server_error: any other handler error or 5xx status
+func classifyError(status int, err error, deadlineExceeded bool) string {
+	var netErr net.Error
+	switch {
+	case errors.Is(err, context.DeadlineExceeded),
+		errors.As(err, &netErr) && netErr.Timeout(),
+		status == http.StatusRequestTimeout,
+		status == http.StatusGatewayTimeout,
+		deadlineExceeded:
+		return errorClassTimeout
+	case status == http.StatusUnauthorized,
+		status == http.StatusForbidden,
+		status == http.StatusProxyAuthRequired:
+		return errorClassAuthFailure
+	case errors.Is(err, context.Canceled), status >= 400 && status < 500:
+		return errorClassClient
+	case err != nil, status >= 500:
+		return errorClassServer
+	}
+	return ""
+}
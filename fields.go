@@ -0,0 +1,63 @@
+package request_logger
+
+import (
+	"encoding/base64"
+	"strings"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// rewriteFields replaces the values of string and byte string fields for
+// which match returns true with the result of fn. Fields of other types are
+// left untouched.
+func rewriteFields(fields []zap.Field, match func(key string) bool, fn func(key, value string) string) {
+	for i := range fields {
+		f := &fields[i]
+		if !match(f.Key) {
+			continue
+		}
+		switch f.Type {
+		case zapcore.StringType:
+			f.String = fn(f.Key, f.String)
+		case zapcore.ByteStringType:
+			if b, ok := f.Interface.([]byte); ok {
+				f.Interface = []byte(fn(f.Key, string(b)))
+			}
+		}
+	}
+}
+
+// bodyFieldKeys are the fields that can hold a request or response body;
+// the _b64 fields hold it base64 encoded
+var bodyFieldKeys = []string{
+	"request_body", "request_body_b64", "request_body_decoded",
+	"response_body", "response_body_b64",
+}
+
+// rewriteBodyFields applies fn to every body field and to the fields named
+// in extra. Base64 encoded bodies are decoded for fn and encoded again.
+func rewriteBodyFields(fields []zap.Field, extra []string, fn func(key, value string) string) {
+	rewriteFields(fields, keySet(append(append([]string(nil), bodyFieldKeys...), extra...)...), func(key, value string) string {
+		if !strings.HasSuffix(key, "_b64") {
+			return fn(key, value)
+		}
+		decoded, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			return fn(key, value)
+		}
+		return base64.StdEncoding.EncodeToString([]byte(fn(key, string(decoded))))
+	})
+}
+
+// keySet returns a match function for rewriteFields matching the given keys
+func keySet(keys ...string) func(string) bool {
+	set := make(map[string]struct{}, len(keys))
+	for _, k := range keys {
+		set[k] = struct{}{}
+	}
+	return func(key string) bool {
+		_, ok := set[key]
+		return ok
+	}
+}
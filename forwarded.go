@@ -0,0 +1,93 @@
+package request_logger
+
+import "strings"
+
+// parseForwarded parses RFC 7239 Forwarded header values into one map per
+// forwarded element, in hop order. Only the for, by, host and proto
+// parameters are kept; quoted values are unquoted.
+func parseForwarded(values []string) []map[string]string {
+	var elements []map[string]string
+	for _, value := range values {
+		for _, element := range splitQuoted(value, ',') {
+			params := make(map[string]string)
+			for _, pair := range splitQuoted(element, ';') {
+				name, val, ok := strings.Cut(pair, "=")
+				if !ok {
+					continue
+				}
+				name = strings.ToLower(strings.TrimSpace(name))
+				switch name {
+				case "for", "by", "host", "proto":
+					params[name] = unquote(strings.TrimSpace(val))
+				}
+			}
+			if len(params) > 0 {
+				elements = append(elements, params)
+			}
+		}
+	}
+	return elements
+}
+
+// maskForwardedFor applies fn to the IP of every for parameter in hops
+func maskForwardedFor(hops []map[string]string, fn func(string) string) {
+	for _, hop := range hops {
+		if node, ok := hop["for"]; ok {
+			hop["for"] = mapForwardedNode(node, fn)
+		}
+	}
+}
+
+// mapForwardedNode applies fn to the IP of a node such as 192.0.2.43:47011
+// or [2001:db8::1]:4711, keeping the port. Obfuscated identifiers and
+// unknown are passed to fn as they are, which leaves them unchanged.
+func mapForwardedNode(node string, fn func(string) string) string {
+	if strings.HasPrefix(node, "[") {
+		end := strings.IndexByte(node, ']')
+		if end < 0 {
+			return node
+		}
+		return "[" + fn(node[1:end]) + "]" + node[end+1:]
+	}
+	if host, port, ok := strings.Cut(node, ":"); ok {
+		return fn(host) + ":" + port
+	}
+	return fn(node)
+}
+
+// splitQuoted splits s at every sep that is not inside a quoted string
+func splitQuoted(s string, sep byte) []string {
+	var parts []string
+	inQuotes, escaped, start := false, false, 0
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case escaped:
+			escaped = false
+		case c == '\\' && inQuotes:
+			escaped = true
+		case c == '"':
+			inQuotes = !inQuotes
+		case c == sep && !inQuotes:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// unquote removes the quotes and backslash escapes of a quoted string,
+// returning unquoted tokens unchanged
+func unquote(s string) string {
+	if len(s) < 2 || s[0] != '"' || s[len(s)-1] != '"' {
+		return s
+	}
+	var b strings.Builder
+	s = s[1 : len(s)-1]
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			i++
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
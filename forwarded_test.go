@@ -0,0 +1,30 @@
+package request_logger
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestForwardedForIsMasked(t *testing.T) {
+	for _, option := range []string{"mask_ip_ranges 0.0.0.0/0 ::/0", "deidentify_pipeline anonymize_ip"} {
+		rl := parseTest(t, "request_logger {\nparse_forwarded_header\n"+option+"\n}")
+		logs := provisionTest(t, rl)
+
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("Forwarded", `for=192.0.2.43:47011;proto=https, for="[2001:db8:cafe::17]:4711", for=unknown`)
+		serveTest(t, rl, r, http.StatusOK, "")
+
+		logged, err := json.Marshal(logs.All()[0].ContextMap()["forwarded"])
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, want := range []string{`"192.0.2.0:47011"`, `"[2001:db8:cafe::]:4711"`, `"unknown"`} {
+			if !strings.Contains(string(logged), want) {
+				t.Errorf("%s: forwarded %s is missing %s", option, logged, want)
+			}
+		}
+	}
+}
@@ -0,0 +1,24 @@
+package request_logger
+
+import (
+	"net"
+	"net/http"
+
+	"go.uber.org/zap"
+)
+
+// http3Fields returns the QUIC details of an HTTP/3 request that are visible
+// to handlers. quic-go's HTTP/3 server only hands the TLS state and the
+// local address to the request, so connection IDs, the QUIC version and
+// transport statistics cannot be logged. A request whose TLS handshake had
+// not completed when it was received arrived as 0-RTT early data.
+func http3Fields(r *http.Request) []zap.Field {
+	var fields []zap.Field
+	if r.TLS != nil {
+		fields = append(fields, zap.Bool("quic_0rtt", !r.TLS.HandshakeComplete))
+	}
+	if addr, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr); ok {
+		fields = append(fields, zap.String("quic_local_addr", addr.String()))
+	}
+	return fields
+}
@@ -0,0 +1,109 @@
+package request_logger
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"time"
+)
+
+// Formats for generated IDs
+const (
+	idFormatHex   = "hex"
+	idFormatUUID  = "uuid"
+	idFormatULID  = "ulid"
+	idFormatKSUID = "ksuid"
+)
+
+const (
+	crockfordAlphabet = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+	base62Alphabet    = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+	// ksuidEpoch is the KSUID timestamp epoch (2014-05-13T16:53:20Z)
+	ksuidEpoch = 1400000000
+)
+
+// validIDFormat reports whether format is a supported ID format
+func validIDFormat(format string) bool {
+	switch format {
+	case idFormatHex, idFormatUUID, idFormatULID, idFormatKSUID:
+		return true
+	default:
+		return false
+	}
+}
+
+// newID generates a random ID in the given format:
+//
+//   - hex: 32 hex characters (128 bits, W3C trace-id compatible)
+//   - uuid: RFC 4122 version 4 UUID
+//   - ulid: 26 character ULID, sortable by millisecond timestamp
+//   - ksuid: 27 character KSUID, sortable by second timestamp
+func newID(format string, now time.Time) string {
+	switch format {
+	case idFormatUUID:
+		return newUUID()
+	case idFormatULID:
+		return newULID(now)
+	case idFormatKSUID:
+		return newKSUID(now)
+	default:
+		var b [16]byte
+		_, _ = rand.Read(b[:])
+		return hex.EncodeToString(b[:])
+	}
+}
+
+// newUUID returns a random version 4 UUID
+func newUUID() string {
+	var b [16]byte
+	_, _ = rand.Read(b[:])
+	b[6] = (b[6] & 0x0f) | 0x40 // version 4
+	b[8] = (b[8] & 0x3f) | 0x80 // RFC 4122 variant
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
+// newULID returns a ULID: a 48-bit millisecond timestamp followed by 80
+// random bits, Crockford base32 encoded
+func newULID(now time.Time) string {
+	var out [26]byte
+
+	ms := uint64(now.UnixMilli())
+	for i := 9; i >= 0; i-- {
+		out[i] = crockfordAlphabet[ms&0x1f]
+		ms >>= 5
+	}
+
+	var entropy [10]byte
+	_, _ = rand.Read(entropy[:])
+	hi := uint64(binary.BigEndian.Uint16(entropy[0:2]))
+	lo := binary.BigEndian.Uint64(entropy[2:10])
+	for i := 25; i >= 10; i-- {
+		out[i] = crockfordAlphabet[lo&0x1f]
+		lo = lo>>5 | (hi&0x1f)<<59
+		hi >>= 5
+	}
+
+	return string(out[:])
+}
+
+// newKSUID returns a KSUID: a 32-bit second timestamp relative to the KSUID
+// epoch followed by 128 random bits, base62 encoded and zero padded
+func newKSUID(now time.Time) string {
+	var b [20]byte
+	binary.BigEndian.PutUint32(b[0:4], uint32(now.Unix()-ksuidEpoch))
+	_, _ = rand.Read(b[4:])
+
+	n := new(big.Int).SetBytes(b[:])
+	base := big.NewInt(62)
+	mod := new(big.Int)
+
+	out := make([]byte, 27)
+	for i := len(out) - 1; i >= 0; i-- {
+		n.DivMod(n, base, mod)
+		out[i] = base62Alphabet[mod.Int64()]
+	}
+	return string(out)
+}
@@ -0,0 +1,125 @@
+package request_logger
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// Prefix lengths kept when masking an address: the host byte of IPv4 and
+// everything past the site prefix of IPv6 are zeroed
+const (
+	maskBitsIPv4 = 24
+	maskBitsIPv6 = 48
+)
+
+// parseIPRanges parses CIDRs such as 10.0.0.0/8 or 2001:db8::/32
+func parseIPRanges(cidrs []string) ([]netip.Prefix, error) {
+	ranges := make([]netip.Prefix, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		prefix, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid IP range %q: %v", cidr, err)
+		}
+		ranges = append(ranges, prefix.Masked())
+	}
+	return ranges, nil
+}
+
+// maskIP zeroes the host part of ip
+func maskIP(ip netip.Addr) netip.Addr {
+	bits := maskBitsIPv6
+	if ip.Is4() {
+		bits = maskBitsIPv4
+	}
+	prefix, err := ip.Prefix(bits)
+	if err != nil {
+		return ip
+	}
+	return prefix.Addr()
+}
+
+// loggedIP returns ip as it should appear in the log: masked if it falls
+// into one of mask_ip_ranges, unchanged otherwise
+func (rl *RequestLogger) loggedIP(ip string) string {
+	if len(rl.maskRanges) == 0 {
+		return ip
+	}
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return ip
+	}
+	addr = addr.Unmap()
+	for _, prefix := range rl.maskRanges {
+		if prefix.Contains(addr) {
+			return maskIP(addr).String()
+		}
+	}
+	return ip
+}
+
+// loggedAddr applies loggedIP to the host of a host:port address
+func (rl *RequestLogger) loggedAddr(addr string) string {
+	return mapAddrHost(addr, rl.loggedIP)
+}
+
+// anonymizeIP masks any IP address; other values are returned unchanged
+func anonymizeIP(ip string) string {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return ip
+	}
+	return maskIP(addr.Unmap()).String()
+}
+
+// mapAddrHost applies fn to the host of a host:port address, or to the
+// whole address if it has no port
+func mapAddrHost(addr string, fn func(string) string) string {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fn(addr)
+	}
+	return net.JoinHostPort(fn(host), port)
+}
+
+// clientIP returns the originating client IP. With trust_forwarded, the
+// leftmost X-Forwarded-For entry or else X-Real-IP is used, but only when
+// the request comes from a trusted proxy. Otherwise it is the IP of the
+// remote address.
+func (rl *RequestLogger) clientIP(r *http.Request) string {
+	ip := remoteIP(r)
+	if !rl.TrustForwarded || !rl.fromTrustedProxy(ip) {
+		return ip
+	}
+
+	if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
+		first, _, _ := strings.Cut(xff, ",")
+		if addr, err := netip.ParseAddr(strings.TrimSpace(first)); err == nil {
+			return addr.Unmap().String()
+		}
+	}
+	if realIP := strings.TrimSpace(r.Header.Get("X-Real-IP")); realIP != "" {
+		if addr, err := netip.ParseAddr(realIP); err == nil {
+			return addr.Unmap().String()
+		}
+	}
+	return ip
+}
+
+// fromTrustedProxy reports whether ip belongs to trusted_proxies. Like
+// Caddy's own trusted_proxies, an empty list trusts no one.
+func (rl *RequestLogger) fromTrustedProxy(ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, prefix := range rl.trustedProxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,56 @@
+package request_logger
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTrustForwardedRequiresTrustedProxies(t *testing.T) {
+	rl := &RequestLogger{TrustForwarded: true}
+	if err := rl.Validate(); err == nil {
+		t.Fatal("expected trust_forwarded without trusted_proxies to be rejected")
+	}
+}
+
+func TestTrustForwardedLogsClientIP(t *testing.T) {
+	rl := parseTest(t, `request_logger {
+		trust_forwarded
+		trusted_proxies 10.0.0.0/8
+	}`)
+	logs := provisionTest(t, rl)
+
+	for _, tc := range []struct {
+		name   string
+		remote string
+		header string
+		value  string
+		want   string
+	}{
+		{"leftmost X-Forwarded-For", "10.1.2.3:4000", "X-Forwarded-For", "203.0.113.7, 10.9.9.9", "203.0.113.7"},
+		{"X-Real-IP", "10.1.2.3:4000", "X-Real-IP", "203.0.113.8", "203.0.113.8"},
+		{"spoofed from untrusted source", "198.51.100.9:4000", "X-Forwarded-For", "203.0.113.7", "198.51.100.9"},
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = tc.remote
+		r.Header.Set(tc.header, tc.value)
+		serveTest(t, rl, r, http.StatusOK, "")
+
+		entries := logs.TakeAll()
+		if len(entries) != 1 {
+			t.Fatalf("%s: got %d entries, want 1", tc.name, len(entries))
+		}
+		fields := entries[0].ContextMap()
+		if fields["client_ip"] != tc.want || fields["remote_addr"] != tc.remote {
+			t.Errorf("%s: logged client_ip %v and remote_addr %v, want %s and %s",
+				tc.name, fields["client_ip"], fields["remote_addr"], tc.want, tc.remote)
+		}
+	}
+}
+
+func TestEmptyTrustedProxiesTrustsNoOne(t *testing.T) {
+	rl := &RequestLogger{}
+	if rl.fromTrustedProxy("127.0.0.1") {
+		t.Fatal("empty trusted_proxies must not trust any source")
+	}
+}
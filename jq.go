@@ -0,0 +1,69 @@
+package request_logger
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/itchyny/gojq"
+)
+
+const (
+	// jqTimeout bounds how long a body filter may run per request
+	jqTimeout = 100 * time.Millisecond
+
+	// jqMaxResults caps the number of values a filter may emit
+	jqMaxResults = 100
+)
+
+// compileJQ parses and compiles a jq filter for body_jq_filter
+func compileJQ(filter string) (*gojq.Code, error) {
+	query, err := gojq.Parse(filter)
+	if err != nil {
+		return nil, fmt.Errorf("invalid body_jq_filter: %v", err)
+	}
+	code, err := gojq.Compile(query)
+	if err != nil {
+		return nil, fmt.Errorf("invalid body_jq_filter: %v", err)
+	}
+	return code, nil
+}
+
+// runJQ applies the filter to a JSON body and returns the result as JSON. A
+// filter emitting several values yields an array of them. It reports false if
+// the body is not valid JSON or the filter fails, so the caller can fall back
+// to the raw body.
+func runJQ(code *gojq.Code, body []byte) ([]byte, bool) {
+	doc, err := parseJSON(body)
+	if err != nil {
+		return nil, false
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), jqTimeout)
+	defer cancel()
+
+	var results []any
+	iter := code.RunWithContext(ctx, doc)
+	for len(results) < jqMaxResults {
+		v, ok := iter.Next()
+		if !ok {
+			break
+		}
+		if _, ok := v.(error); ok {
+			return nil, false
+		}
+		results = append(results, v)
+	}
+
+	var out []byte
+	if len(results) == 1 {
+		out, err = json.Marshal(results[0])
+	} else {
+		out, err = json.Marshal(results)
+	}
+	if err != nil {
+		return nil, false
+	}
+	return out, true
+}
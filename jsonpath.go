@@ -0,0 +1,143 @@
+package request_logger
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime"
+	"strconv"
+	"strings"
+)
+
+// isJSONContentType reports whether the content type is JSON, including
+// structured syntax suffixes such as application/problem+json
+func isJSONContentType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
+
+// parseJSON decodes a JSON document, keeping numbers as json.Number so they
+// are logged exactly as sent
+func parseJSON(data []byte) (any, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// lookupJSONPath returns the value at a dot-separated path such as
+// user.id or items.0.sku, where numeric segments index into arrays
+func lookupJSONPath(v any, path string) (any, bool) {
+	for _, segment := range strings.Split(path, ".") {
+		switch node := v.(type) {
+		case map[string]any:
+			child, ok := node[segment]
+			if !ok {
+				return nil, false
+			}
+			v = child
+		case []any:
+			i, err := strconv.Atoi(segment)
+			if err != nil || i < 0 || i >= len(node) {
+				return nil, false
+			}
+			v = node[i]
+		default:
+			return nil, false
+		}
+	}
+	return v, true
+}
+
+// setJSONPath stores value at a dot-separated path in dst, creating
+// intermediate objects as needed
+func setJSONPath(dst map[string]any, path string, value any) {
+	segments := strings.Split(path, ".")
+	for _, segment := range segments[:len(segments)-1] {
+		child, ok := dst[segment].(map[string]any)
+		if !ok {
+			child = make(map[string]any)
+			dst[segment] = child
+		}
+		dst = child
+	}
+	dst[segments[len(segments)-1]] = value
+}
+
+// allowJSONFields returns a JSON document containing only the allowed paths
+// of body. It reports false if body is not valid JSON.
+func allowJSONFields(body []byte, allowed []string) ([]byte, bool) {
+	doc, err := parseJSON(body)
+	if err != nil {
+		return nil, false
+	}
+
+	filtered := make(map[string]any)
+	for _, path := range allowed {
+		if value, ok := lookupJSONPath(doc, path); ok {
+			setJSONPath(filtered, path, value)
+		}
+	}
+
+	out, err := json.Marshal(filtered)
+	if err != nil {
+		return nil, false
+	}
+	return out, true
+}
+
+// extractJSONFields returns the values at the given paths of a parsed
+// document keyed by path. Paths missing from the document are left out.
+func extractJSONFields(doc any, paths []string) map[string]any {
+	extracted := make(map[string]any, len(paths))
+	for _, path := range paths {
+		if value, ok := lookupJSONPath(doc, path); ok {
+			extracted[path] = value
+		}
+	}
+	return extracted
+}
+
+// replaceJSONPath replaces the value at an existing dot-separated path of v
+// with value, or deletes it if remove is set. Removed array elements become
+// null so the indices of the others stay the same.
+func replaceJSONPath(v any, path string, value any, remove bool) {
+	parentPath, last := "", path
+	if i := strings.LastIndexByte(path, '.'); i >= 0 {
+		parentPath, last = path[:i], path[i+1:]
+	}
+	parent := v
+	if parentPath != "" {
+		var ok bool
+		if parent, ok = lookupJSONPath(v, parentPath); !ok {
+			return
+		}
+	}
+
+	switch node := parent.(type) {
+	case map[string]any:
+		if _, ok := node[last]; !ok {
+			return
+		}
+		if remove {
+			delete(node, last)
+		} else {
+			node[last] = value
+		}
+	case []any:
+		i, err := strconv.Atoi(last)
+		if err != nil || i < 0 || i >= len(node) {
+			return
+		}
+		if remove {
+			value = nil
+		}
+		node[i] = value
+	}
+}
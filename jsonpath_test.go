@@ -0,0 +1,83 @@
+package request_logger
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// extractedFields logs a JSON request body through a logger configured
+// with options and returns the entry's fields encoded as JSON
+func extractedFields(t *testing.T, options string) string {
+	t.Helper()
+	rl := parseTest(t, "request_logger {\n"+options+"\n}")
+	logs := provisionTest(t, rl)
+
+	body := `{"user":{"id":42,"email":"` + piiEmail + `"},"secret":"hunter2"}`
+	r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	serveTest(t, rl, r, http.StatusOK, "")
+
+	entries := logs.All()
+	if len(entries) != 1 {
+		t.Fatalf("got %d entries, want 1", len(entries))
+	}
+	encoded, err := json.Marshal(entries[0].ContextMap())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(encoded)
+}
+
+func TestExtractedBodyFieldsAreProtected(t *testing.T) {
+	tests := []struct {
+		name    string
+		options string
+		leak    string // must not appear in the entry
+		want    string // must appear in the entry
+	}{
+		{
+			name:    "context field outside allowlist",
+			options: "body_allowed_fields user.id\nbody_context_fields {\nemail user.email\nuser_id user.id\n}",
+			leak:    piiEmail,
+			want:    `"user_id":`,
+		},
+		{
+			name:    "context field with body strategy",
+			options: "body_context_fields {\nemail user.email\n}\nredaction_strategies {\nbody:user.email mask\n}",
+			leak:    piiEmail,
+			want:    `"email":"` + redactedValue + `"`,
+		},
+		{
+			name:    "context field with tokenize_pii",
+			options: "body_context_fields {\nemail user.email\n}\ntokenize_pii\npii_key secret",
+			leak:    piiEmail,
+			want:    `"email":"email_`,
+		},
+		{
+			name:    "json_fields outside allowlist",
+			options: "include_request_body\nbody_allowed_fields user.id\nlog_json_fields user.id secret",
+			leak:    "hunter2",
+			want:    `"user.id":42`,
+		},
+		{
+			name:    "json_fields with scrub_body_pii",
+			options: "include_request_body\nlog_json_fields user.email\ndeidentify_pipeline scrub_body_pii",
+			leak:    piiEmail,
+			want:    `"user.email":"[email]"`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entry := extractedFields(t, tt.options)
+			if strings.Contains(entry, tt.leak) {
+				t.Errorf("entry leaks %q: %s", tt.leak, entry)
+			}
+			if !strings.Contains(entry, tt.want) {
+				t.Errorf("entry lacks %s: %s", tt.want, entry)
+			}
+		})
+	}
+}
@@ -0,0 +1,72 @@
+package request_logger
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// metrics about the logger itself, exposed through Caddy's metrics endpoint
+var loggerMetrics = struct {
+	init           sync.Once
+	logged         *prometheus.CounterVec
+	skipped        *prometheus.CounterVec
+	webhookDropped prometheus.Counter
+}{}
+
+// initMetrics registers the collectors with the default registry, which
+// Caddy serves; it is safe to call from every Provision
+func initMetrics() {
+	loggerMetrics.init.Do(func() {
+		const ns = "request_logger"
+		loggerMetrics.logged = promauto.NewCounterVec(prometheus.CounterOpts{
+			Namespace: ns,
+			Name:      "logged_total",
+			Help:      "Counter of requests logged by request_logger.",
+		}, []string{"method", "status"})
+		loggerMetrics.skipped = promauto.NewCounterVec(prometheus.CounterOpts{
+			Namespace: ns,
+			Name:      "skipped_total",
+			Help:      "Counter of requests not logged by request_logger, by reason.",
+		}, []string{"reason"})
+		loggerMetrics.webhookDropped = promauto.NewCounter(prometheus.CounterOpts{
+			Namespace: ns,
+			Name:      "webhook_dropped_total",
+			Help:      "Counter of log entries dropped because the webhook buffer was full.",
+		})
+	})
+}
+
+// countLogged records a logged request; a status of 0 means the entry was
+// written before the response was known
+func countLogged(method string, status int) {
+	label := "unknown"
+	if status != 0 {
+		label = strconv.Itoa(status)
+	}
+	loggerMetrics.logged.WithLabelValues(metricMethod(method), label).Inc()
+}
+
+// metricMethod maps non-standard methods to OTHER so clients cannot blow up
+// the label cardinality
+func metricMethod(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+		http.MethodDelete, http.MethodConnect, http.MethodOptions, http.MethodTrace:
+		return method
+	}
+	return "OTHER"
+}
+
+// countWebhookDropped records an entry the webhook could not buffer
+func countWebhookDropped() {
+	loggerMetrics.webhookDropped.Inc()
+}
+
+// countSkipped records a request that was not logged
+func countSkipped(reason string) {
+	loggerMetrics.skipped.WithLabelValues(reason).Inc()
+}
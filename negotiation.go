@@ -0,0 +1,51 @@
+package request_logger
+
+import (
+	"mime"
+	"strconv"
+	"strings"
+)
+
+// acceptsMediaType reports whether an Accept header value allows the given
+// media type. The most specific matching range decides, so that
+// "text/*;q=0, text/html" still accepts text/html. An empty header accepts
+// everything.
+func acceptsMediaType(accept, mediaType string) bool {
+	if strings.TrimSpace(accept) == "" {
+		return true
+	}
+	typ, _, _ := strings.Cut(mediaType, "/")
+
+	best, accepted := -1, false
+	for _, part := range strings.Split(accept, ",") {
+		rangeType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		rangeMain, rangeSub, _ := strings.Cut(rangeType, "/")
+
+		var specificity int
+		switch {
+		case rangeType == mediaType:
+			specificity = 2
+		case rangeMain == typ && rangeSub == "*":
+			specificity = 1
+		case rangeType == "*/*":
+			specificity = 0
+		default:
+			continue
+		}
+		if specificity <= best {
+			continue
+		}
+
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if parsed, err := strconv.ParseFloat(v, 64); err == nil {
+				q = parsed
+			}
+		}
+		best, accepted = specificity, q > 0
+	}
+	return accepted
+}
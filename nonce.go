@@ -0,0 +1,87 @@
+package request_logger
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"go.uber.org/zap"
+)
+
+// ReplayDetection flags requests that reuse a nonce or carry a timestamp
+// outside the allowed window, which suggests a replayed request
+type ReplayDetection struct {
+	// Header carrying a unique nonce per request, e.g. X-Nonce
+	NonceHeader string `json:"nonce_header,omitempty"`
+
+	// Header carrying the time the request was made, as Unix seconds or
+	// RFC 3339, e.g. X-Timestamp
+	TimestampHeader string `json:"timestamp_header,omitempty"`
+
+	// How long nonces are remembered and how far timestamps may be from
+	// now (default 5m)
+	Window caddy.Duration `json:"window,omitempty"`
+
+	// Maximum number of nonces remembered (default 100000)
+	MaxNonces int `json:"max_nonces,omitempty"`
+
+	nonces *windowCounter
+}
+
+// provision applies defaults and validates the configuration
+func (rd *ReplayDetection) provision() error {
+	if rd.NonceHeader == "" && rd.TimestampHeader == "" {
+		return fmt.Errorf("replay_detection requires nonce_header or timestamp_header")
+	}
+	if rd.Window == 0 {
+		rd.Window = caddy.Duration(5 * time.Minute)
+	}
+	if rd.MaxNonces == 0 {
+		rd.MaxNonces = 100000
+	}
+	if rd.Window < 0 || rd.MaxNonces < 0 {
+		return fmt.Errorf("replay_detection window and max_nonces must be positive")
+	}
+	if rd.NonceHeader != "" {
+		rd.nonces = newWindowCounter(time.Duration(rd.Window), rd.MaxNonces)
+	}
+	return nil
+}
+
+// Check records the request's nonce and returns replay_suspect along with
+// the reason when the nonce was already seen within the window or the
+// timestamp is missing, malformed or outside the window
+func (rd *ReplayDetection) Check(r *http.Request, now time.Time) []zap.Field {
+	reason := ""
+	if rd.TimestampHeader != "" {
+		if ts, ok := parseRequestTimestamp(r.Header.Get(rd.TimestampHeader)); !ok {
+			reason = "invalid_timestamp"
+		} else if skew := now.Sub(ts); skew > time.Duration(rd.Window) || -skew > time.Duration(rd.Window) {
+			reason = "stale_timestamp"
+		}
+	}
+	if rd.nonces != nil {
+		if nonce := r.Header.Get(rd.NonceHeader); nonce != "" && rd.nonces.Increment(nonce, now) > 1 {
+			reason = "nonce_reused"
+		}
+	}
+
+	if reason == "" {
+		return nil
+	}
+	return []zap.Field{zap.Bool("replay_suspect", true), zap.String("replay_reason", reason)}
+}
+
+// parseRequestTimestamp parses Unix seconds or an RFC 3339 time
+func parseRequestTimestamp(value string) (time.Time, bool) {
+	if value == "" {
+		return time.Time{}, false
+	}
+	if secs, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(secs, 0), true
+	}
+	ts, err := time.Parse(time.RFC3339, value)
+	return ts, err == nil
+}
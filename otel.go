@@ -0,0 +1,53 @@
+package request_logger
+
+import (
+	"fmt"
+	"net/http"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// attachToSpan records the log fields as an event on the request's active
+// OpenTelemetry span. It reports false if there is no recording span.
+func attachToSpan(r *http.Request, message string, fields []zap.Field) bool {
+	span := trace.SpanFromContext(r.Context())
+	if !span.IsRecording() {
+		return false
+	}
+
+	enc := zapcore.NewMapObjectEncoder()
+	for _, f := range fields {
+		f.AddTo(enc)
+	}
+
+	attrs := make([]attribute.KeyValue, 0, len(enc.Fields))
+	for key, value := range enc.Fields {
+		attrs = append(attrs, spanAttribute(key, value))
+	}
+
+	span.AddEvent(message, trace.WithAttributes(attrs...))
+	return true
+}
+
+// spanAttribute converts an encoded log field value to a span attribute
+func spanAttribute(key string, value any) attribute.KeyValue {
+	switch v := value.(type) {
+	case string:
+		return attribute.String(key, v)
+	case bool:
+		return attribute.Bool(key, v)
+	case int64:
+		return attribute.Int64(key, v)
+	case int:
+		return attribute.Int(key, v)
+	case float64:
+		return attribute.Float64(key, v)
+	case []string:
+		return attribute.StringSlice(key, v)
+	default:
+		return attribute.String(key, fmt.Sprint(v))
+	}
+}
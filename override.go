@@ -0,0 +1,72 @@
+package request_logger
+
+import (
+	"fmt"
+	pathpkg "path"
+	"strings"
+
+	"github.com/caddyserver/caddy/v2"
+)
+
+// PathOverride replaces the logger configuration for requests whose path
+// matches Path. Overrides are evaluated in order and the first match wins;
+// its settings start from the module defaults, not the top-level ones.
+type PathOverride struct {
+	// Path pattern: a prefix ending in *, e.g. /api/*, or a shell-style
+	// glob matched against the whole path
+	Path string `json:"path"`
+
+	// Skip logging for matching requests entirely
+	Skip bool `json:"skip,omitempty"`
+
+	// Configuration used for matching requests
+	Logger *RequestLogger `json:"logger,omitempty"`
+}
+
+// matches reports whether the override applies to path
+func (po PathOverride) matches(path string) bool {
+	return matchPathPattern(po.Path, path)
+}
+
+// matchPathPattern reports whether path matches a prefix pattern ending in
+// *, e.g. /api/*, or a shell-style glob matched against the whole path
+func matchPathPattern(pattern, path string) bool {
+	if prefix, ok := strings.CutSuffix(pattern, "*"); ok && !strings.ContainsAny(prefix, "*?[") {
+		return strings.HasPrefix(path, prefix)
+	}
+	matched, _ := pathpkg.Match(pattern, path)
+	return matched
+}
+
+// provisionPathOverrides validates the overrides and provisions their
+// loggers
+func (rl *RequestLogger) provisionPathOverrides(ctx caddy.Context) error {
+	for i, po := range rl.PathOverrides {
+		if po.Path == "" {
+			return fmt.Errorf("path_override %d requires a path", i)
+		}
+		if _, err := pathpkg.Match(po.Path, "/"); err != nil {
+			return fmt.Errorf("invalid path_override %q: %v", po.Path, err)
+		}
+		if po.Skip {
+			continue
+		}
+		if po.Logger == nil {
+			return fmt.Errorf("path_override %q requires settings or skip", po.Path)
+		}
+		if err := po.Logger.Provision(ctx); err != nil {
+			return fmt.Errorf("path_override %q: %v", po.Path, err)
+		}
+	}
+	return nil
+}
+
+// pathOverride returns the first override matching path, or nil
+func (rl *RequestLogger) pathOverride(path string) *PathOverride {
+	for i := range rl.PathOverrides {
+		if rl.PathOverrides[i].matches(path) {
+			return &rl.PathOverrides[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,44 @@
+package request_logger
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"regexp"
+)
+
+// piiPattern is a kind of personal data recognized in logged values
+type piiPattern struct {
+	kind string
+	re   *regexp.Regexp
+}
+
+// piiPatterns are matched in order, so more specific patterns come first
+var piiPatterns = []piiPattern{
+	{kind: "email", re: regexp.MustCompile(`[A-Za-z0-9._%+\-]+@[A-Za-z0-9.\-]+\.[A-Za-z]{2,}`)},
+	{kind: "card", re: regexp.MustCompile(`\b(?:\d[ \-]?){12,18}\d\b`)},
+	{kind: "phone", re: regexp.MustCompile(`\+?\d{1,3}[ \-.]?\(?\d{2,4}\)?[ \-.]?\d{3,4}[ \-.]?\d{4}\b`)},
+}
+
+// piiTokenizer replaces personal data with stable keyed tokens, so the same
+// value always maps to the same token without revealing the value itself
+type piiTokenizer struct {
+	key []byte
+}
+
+// Token returns the token for value, e.g. email_a1b2c3d4e5f6
+func (t piiTokenizer) Token(kind, value string) string {
+	mac := hmac.New(sha256.New, t.key)
+	mac.Write([]byte(value))
+	return kind + "_" + hex.EncodeToString(mac.Sum(nil))[:12]
+}
+
+// Tokenize replaces every PII match in s with its token
+func (t piiTokenizer) Tokenize(s string) string {
+	for _, p := range piiPatterns {
+		s = p.re.ReplaceAllStringFunc(s, func(match string) string {
+			return t.Token(p.kind, match)
+		})
+	}
+	return s
+}
@@ -0,0 +1,151 @@
+package request_logger
+
+import (
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+const (
+	// rateSmoothing is the weight of the latest second in the moving average
+	rateSmoothing = 0.1
+
+	// rateWarmup is how many seconds are observed before anomalies are flagged
+	rateWarmup = 10
+)
+
+// rateBaseline tracks the request rate in one-second buckets and keeps an
+// exponentially weighted moving average of it as the baseline. Each request
+// costs a few arithmetic operations under a mutex and the state is constant
+// in size.
+type rateBaseline struct {
+	mu      sync.Mutex
+	second  int64   // current bucket, in unix seconds
+	count   float64 // requests in the current bucket
+	average float64 // moving average of requests per second
+	seconds int     // buckets folded into the average, capped at rateWarmup
+}
+
+// Observe records a request at now and returns the number of requests seen
+// in the current second together with the baseline rate. The baseline is
+// zero until enough seconds have been observed.
+func (b *rateBaseline) Observe(now time.Time) (current, baseline float64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	sec := now.Unix()
+	switch {
+	case b.second == 0:
+		b.second = sec
+	case sec > b.second:
+		// Fold the finished bucket into the average, then decay it for any
+		// idle seconds in between
+		b.average += rateSmoothing * (b.count - b.average)
+		if idle := sec - b.second - 1; idle > 0 {
+			b.average *= math.Pow(1-rateSmoothing, float64(idle))
+		}
+		b.seconds = min(b.seconds+int(sec-b.second), rateWarmup)
+		b.second = sec
+		b.count = 0
+	}
+	b.count++
+
+	if b.seconds < rateWarmup {
+		return b.count, 0
+	}
+	return b.count, b.average
+}
+
+// adaptiveSampler keeps the log rate near a target by sampling requests with
+// probability target/rate, where rate is a decaying estimate of requests per
+// second: every request adds one and the total decays with a one second
+// time constant.
+type adaptiveSampler struct {
+	mu     sync.Mutex
+	target float64
+	rate   float64
+	last   time.Time
+}
+
+func newAdaptiveSampler(target float64) *adaptiveSampler {
+	return &adaptiveSampler{target: target}
+}
+
+// Sample records a request at now and reports whether it should be logged,
+// along with the sampling probability that applied to it
+func (s *adaptiveSampler) Sample(now time.Time) (bool, float64) {
+	s.mu.Lock()
+	if !s.last.IsZero() {
+		s.rate *= math.Exp(-now.Sub(s.last).Seconds())
+	}
+	s.rate++
+	s.last = now
+	p := min(1, s.target/s.rate)
+	s.mu.Unlock()
+
+	return p >= 1 || rand.Float64() < p, p
+}
+
+// freeBodyFraction is the largest part of the budget an endpoint's first
+// body of a second may take regardless of the endpoint's share
+const freeBodyFraction = 0.1
+
+// bodyBudget caps the bytes of request bodies logged per second. The cap
+// holds across all endpoints, however the traffic is spread. Within it,
+// the budget is split evenly between the endpoints seen in the previous
+// second, so a popular endpoint exhausts its share and has bodies skipped
+// while rare endpoints keep logging theirs. State is reset every second and
+// only covers endpoints active in the last two seconds.
+type bodyBudget struct {
+	mu        sync.Mutex
+	limit     float64
+	second    int64
+	total     int64            // body bytes logged this second
+	endpoints int              // endpoints active in the previous second
+	spent     map[string]int64 // body bytes logged per endpoint this second
+}
+
+func newBodyBudget(bytesPerSec int) *bodyBudget {
+	return &bodyBudget{limit: float64(bytesPerSec), spent: make(map[string]int64)}
+}
+
+// Allow records a body of n bytes for endpoint at now and reports whether it
+// fits in the budget and the endpoint's share of it
+func (b *bodyBudget) Allow(endpoint string, n int, now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if sec := now.Unix(); sec != b.second {
+		b.endpoints = 0
+		if sec == b.second+1 {
+			b.endpoints = len(b.spent)
+		}
+		b.second = sec
+		b.total = 0
+		clear(b.spent)
+	}
+
+	if float64(b.total+int64(n)) > b.limit {
+		return false
+	}
+
+	spent, seen := b.spent[endpoint]
+	active := b.endpoints
+	if !seen {
+		active = max(active, len(b.spent)+1)
+	} else {
+		active = max(active, len(b.spent))
+	}
+	share := b.limit / float64(active)
+
+	// An endpoint's first small body of the second is allowed beyond its
+	// share so that rare endpoints are not starved by many others
+	free := !seen && float64(n) <= b.limit*freeBodyFraction
+	if !free && float64(spent+int64(n)) > share {
+		return false
+	}
+	b.spent[endpoint] = spent + int64(n)
+	b.total += int64(n)
+	return true
+}
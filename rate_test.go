@@ -0,0 +1,63 @@
+package request_logger
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestBodyBudgetHoldsAcrossDistinctPaths(t *testing.T) {
+	const limit = 10000
+	b := newBodyBudget(limit)
+	start := time.Unix(1700000000, 0)
+
+	for sec := 0; sec < 3; sec++ {
+		var logged int
+		for i := 0; i < 1000; i++ {
+			// Slugs are not collapsed by requestRoute, so every request is an
+			// endpoint of its own
+			endpoint := fmt.Sprintf("GET /search/word-%d-%d", sec, i)
+			now := start.Add(time.Duration(sec)*time.Second + time.Duration(i)*time.Microsecond)
+			if b.Allow(endpoint, 500, now) {
+				logged += 500
+			}
+		}
+		if logged > limit {
+			t.Errorf("second %d: logged %d bytes, budget is %d", sec, logged, limit)
+		}
+		if logged == 0 {
+			t.Errorf("second %d: no body logged", sec)
+		}
+	}
+}
+
+func TestBodyBudgetCapsFreeFirstBody(t *testing.T) {
+	b := newBodyBudget(10000)
+	now := time.Unix(1700000000, 0)
+
+	if !b.Allow("GET /a", 1000, now) {
+		t.Error("small first body rejected")
+	}
+	for i := 0; i < 9; i++ {
+		b.Allow(fmt.Sprintf("GET /busy/%d", i), 100, now)
+	}
+	// Ten endpoints share 10000 bytes; a first body above the free part of
+	// the budget must fit the endpoint's share
+	if b.Allow("GET /b", 5000, now) {
+		t.Error("large first body allowed beyond its share")
+	}
+}
+
+func TestBodyBudgetSharesBetweenEndpoints(t *testing.T) {
+	b := newBodyBudget(1000)
+	now := time.Unix(1700000000, 0)
+
+	b.Allow("GET /popular", 100, now)
+	b.Allow("GET /rare", 100, now)
+	for i := 0; i < 10; i++ {
+		b.Allow("GET /popular", 100, now)
+	}
+	if !b.Allow("GET /rare", 100, now) {
+		t.Error("rare endpoint starved by a popular one")
+	}
+}
@@ -0,0 +1,190 @@
+package request_logger
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// Redaction strategies for redaction_strategies
+const (
+	strategyMask     = "mask"
+	strategyPartial  = "partial"
+	strategyHash     = "hash"
+	strategyTokenize = "tokenize"
+	strategyRemove   = "remove"
+)
+
+// partialVisible is how many trailing characters the partial strategy keeps
+const partialVisible = 4
+
+// redactionStrategies applies a strategy per log field, request header or
+// JSON body path. Targets are written as a log field name (query), a
+// header name prefixed with header: (header:Authorization) or a body path
+// prefixed with body: (body:card.number).
+type redactionStrategies struct {
+	fields  map[string]string
+	headers map[string]string // keyed by canonical header name
+	body    map[string]string
+	tokens  *piiTokenizer
+}
+
+// newRedactionStrategies validates the configured strategies. key is the
+// HMAC key for the tokenize strategy and is only required if it is used.
+func newRedactionStrategies(config map[string]string, key string) (*redactionStrategies, error) {
+	rs := &redactionStrategies{
+		fields:  make(map[string]string),
+		headers: make(map[string]string),
+		body:    make(map[string]string),
+	}
+	for target, strategy := range config {
+		switch strategy {
+		case strategyMask, strategyPartial, strategyHash, strategyRemove:
+		case strategyTokenize:
+			if key == "" {
+				return nil, fmt.Errorf("redaction strategy tokenize for %q requires pii_key", target)
+			}
+			rs.tokens = &piiTokenizer{key: []byte(key)}
+		default:
+			return nil, fmt.Errorf("unknown redaction strategy %q for %q (expected mask, partial, hash, tokenize or remove)", strategy, target)
+		}
+
+		switch {
+		case strings.HasPrefix(target, "header:"):
+			rs.headers[http.CanonicalHeaderKey(strings.TrimPrefix(target, "header:"))] = strategy
+		case strings.HasPrefix(target, "body:"):
+			rs.body[strings.TrimPrefix(target, "body:")] = strategy
+		default:
+			rs.fields[target] = strategy
+		}
+	}
+	return rs, nil
+}
+
+// redact applies strategy to value. It reports false if the value is to be
+// removed.
+func (rs *redactionStrategies) redact(strategy, name, value string) (string, bool) {
+	switch strategy {
+	case strategyMask:
+		return redactedValue, true
+	case strategyPartial:
+		runes := []rune(value)
+		if len(runes) <= partialVisible {
+			return strings.Repeat("*", len(runes)), true
+		}
+		return strings.Repeat("*", len(runes)-partialVisible) + string(runes[len(runes)-partialVisible:]), true
+	case strategyHash:
+		sum := sha256.Sum256([]byte(value))
+		return hex.EncodeToString(sum[:]), true
+	case strategyTokenize:
+		return rs.tokens.Token(name, value), true
+	}
+	return "", false
+}
+
+// Apply rewrites fields according to the configured strategies
+func (rs *redactionStrategies) Apply(fields []zap.Field) {
+	for i := range fields {
+		f := &fields[i]
+		if strategy, ok := rs.fields[f.Key]; ok {
+			rs.applyField(f, strategy)
+			continue
+		}
+		switch f.Key {
+		case "headers", "resp_headers":
+			if len(rs.headers) > 0 && (f.Type == zapcore.ReflectType || f.Type == zapcore.StringType) {
+				if f.Type == zapcore.StringType {
+					f.String = rs.redactHeaders(f.String).(string)
+				} else {
+					f.Interface = rs.redactHeaders(f.Interface)
+				}
+			}
+		case "request_body", "response_body":
+			if len(rs.body) > 0 && f.Type == zapcore.ByteStringType {
+				if b, ok := f.Interface.([]byte); ok {
+					f.Interface = rs.redactBody(b)
+				}
+			}
+		}
+	}
+
+	if len(rs.headers) > 0 {
+		rewriteCaddyRequest(fields, func(cr *caddyRequest) {
+			cr.headers = rs.redactHeaders(cr.headers)
+		})
+	}
+}
+
+// applyField applies strategy to a whole log field. Fields that are not
+// strings are only affected by remove.
+func (rs *redactionStrategies) applyField(f *zap.Field, strategy string) {
+	var value string
+	switch f.Type {
+	case zapcore.StringType:
+		value = f.String
+	case zapcore.ByteStringType:
+		b, _ := f.Interface.([]byte)
+		value = string(b)
+	default:
+		if strategy == strategyRemove {
+			*f = zap.Skip()
+		}
+		return
+	}
+
+	redacted, keep := rs.redact(strategy, f.Key, value)
+	switch {
+	case !keep:
+		*f = zap.Skip()
+	case f.Type == zapcore.StringType:
+		f.String = redacted
+	default:
+		f.Interface = []byte(redacted)
+	}
+}
+
+// redactHeaders applies the header strategies to collected headers
+func (rs *redactionStrategies) redactHeaders(headers any) any {
+	return mapHeaders(headers, func(name, value string) (string, bool) {
+		if strategy, ok := rs.headers[http.CanonicalHeaderKey(name)]; ok {
+			return rs.redact(strategy, strings.ToLower(name), value)
+		}
+		return value, true
+	})
+}
+
+// redactBody applies the body path strategies to a JSON body. Bodies that
+// are not valid JSON are returned unchanged.
+func (rs *redactionStrategies) redactBody(body []byte) []byte {
+	doc, err := parseJSON(body)
+	if err != nil {
+		return body
+	}
+
+	for path, strategy := range rs.body {
+		value, ok := lookupJSONPath(doc, path)
+		if !ok {
+			continue
+		}
+		str, isString := value.(string)
+		if !isString {
+			encoded, _ := json.Marshal(value)
+			str = string(encoded)
+		}
+		name := path[strings.LastIndexByte(path, '.')+1:]
+		redacted, keep := rs.redact(strategy, name, str)
+		replaceJSONPath(doc, path, redacted, !keep)
+	}
+
+	out, err := json.Marshal(doc)
+	if err != nil {
+		return body
+	}
+	return out
+}
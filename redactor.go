@@ -0,0 +1,57 @@
+package request_logger
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Redactor rewrites sensitive values before they are logged. field is the
+// log field the value belongs to, e.g. request_body or query.
+//
+// Custom redactors are compiled in by registering them from an init
+// function, typically in a file guarded by a build tag:
+//
+//	//go:build myorg_redactor
+//
+//	func init() {
+//		request_logger.RegisterRedactor("myorg", myorgRedactor{})
+//	}
+//
+// and selected with the redactor directive.
+type Redactor interface {
+	Redact(field string, value []byte) []byte
+}
+
+// noopRedactor returns values unchanged
+type noopRedactor struct{}
+
+func (noopRedactor) Redact(_ string, value []byte) []byte { return value }
+
+var (
+	redactorsMu sync.RWMutex
+	redactors   = map[string]Redactor{"noop": noopRedactor{}}
+)
+
+// RegisterRedactor makes a redactor available under name. It panics if
+// name is already registered, so conflicting builds fail at startup.
+func RegisterRedactor(name string, r Redactor) {
+	redactorsMu.Lock()
+	defer redactorsMu.Unlock()
+
+	if _, ok := redactors[name]; ok {
+		panic(fmt.Sprintf("redactor already registered: %s", name))
+	}
+	redactors[name] = r
+}
+
+// lookupRedactor returns the redactor registered under name
+func lookupRedactor(name string) (Redactor, error) {
+	redactorsMu.RLock()
+	defer redactorsMu.RUnlock()
+
+	r, ok := redactors[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown redactor %q", name)
+	}
+	return r, nil
+}
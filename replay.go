@@ -0,0 +1,233 @@
+package request_logger
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/caddyserver/caddy/v2"
+)
+
+func init() {
+	caddy.RegisterModule(replayAdmin{})
+}
+
+// replayEntry is a captured request that can be replayed
+type replayEntry struct {
+	Method    string      `json:"method"`
+	URL       string      `json:"url"`
+	Header    http.Header `json:"headers"`
+	Body      []byte      `json:"body,omitempty"`
+	Truncated bool        `json:"body_truncated,omitempty"`
+	Omitted   bool        `json:"body_omitted,omitempty"`
+}
+
+// curl returns a curl command line reproducing the request
+func (e *replayEntry) curl() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "curl -X %s %s", e.Method, shellQuote(e.URL))
+
+	names := make([]string, 0, len(e.Header))
+	for name := range e.Header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, value := range e.Header[name] {
+			fmt.Fprintf(&b, " \\\n  -H %s", shellQuote(name+": "+value))
+		}
+	}
+	if len(e.Body) > 0 {
+		fmt.Fprintf(&b, " \\\n  --data-binary %s", shellQuote(string(e.Body)))
+	}
+	b.WriteString("\n")
+	return b.String()
+}
+
+// shellQuote quotes s for POSIX shells
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// replayStore keeps the most recent captured requests by trace ID. It is
+// shared by all request_logger instances so the admin endpoint can serve
+// them; its capacity is the largest replay_store_size of the instances
+// currently provisioned.
+type replayStore struct {
+	mu       sync.Mutex
+	sizes    map[int]int // replay_store_size -> instances using it
+	capacity int
+	entries  map[string]*replayEntry
+	order    []string // trace IDs, oldest first
+}
+
+var replays = &replayStore{
+	sizes:   make(map[int]int),
+	entries: make(map[string]*replayEntry),
+}
+
+// Acquire registers an instance keeping n entries
+func (s *replayStore) Acquire(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sizes[n]++
+	s.resize()
+}
+
+// Release unregisters an instance acquired with n, shrinking the store if
+// no remaining instance needs as many entries
+func (s *replayStore) Release(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.sizes[n]--; s.sizes[n] <= 0 {
+		delete(s.sizes, n)
+	}
+	s.resize()
+}
+
+// resize sets the capacity to the largest registered size. s.mu must be
+// held.
+func (s *replayStore) resize() {
+	s.capacity = 0
+	for n := range s.sizes {
+		s.capacity = max(s.capacity, n)
+	}
+	s.evict()
+}
+
+// evict drops the oldest entries beyond capacity. s.mu must be held.
+func (s *replayStore) evict() {
+	for len(s.order) > s.capacity {
+		delete(s.entries, s.order[0])
+		s.order = s.order[1:]
+	}
+}
+
+// Put stores an entry, evicting the oldest ones beyond capacity
+func (s *replayStore) Put(traceID string, entry *replayEntry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.entries[traceID]; !ok {
+		s.order = append(s.order, traceID)
+	}
+	s.entries[traceID] = entry
+	s.evict()
+}
+
+// Get returns the entry stored for traceID
+func (s *replayStore) Get(traceID string) (*replayEntry, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry, ok := s.entries[traceID]
+	return entry, ok
+}
+
+// replayEntryFor captures r for the replay store. It gets the same
+// protection as the log itself: excluded headers are left out, credentials
+// and redacted headers are masked, redacted query parameters are masked and
+// the body goes through body_allowed_fields and the body PII controls.
+func (rl *RequestLogger) replayEntryFor(r *http.Request, body []byte, truncated bool) *replayEntry {
+	header := make(http.Header, len(r.Header))
+	for name, values := range r.Header {
+		if !rl.isHeaderExcluded(name) {
+			header[name] = values
+		}
+	}
+	header = redactHeaders(header, rl.RedactHeaders).(http.Header)
+	header = redactHeaders(header, sensitiveHeaders).(http.Header)
+	if rl.strategies != nil {
+		header = rl.strategies.redactHeaders(header).(http.Header)
+	}
+	if r.Host != "" {
+		header.Set("Host", r.Host)
+	}
+
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	uri := redactURIQuery(r.URL.RequestURI(), rl.RedactQueryParams)
+	if path, query, ok := strings.Cut(uri, "?"); ok && slices.Contains(rl.DeidentifyPipeline, "clean_query") {
+		uri = path + "?" + cleanQuery(query)
+	}
+
+	entry := &replayEntry{
+		Method:    r.Method,
+		URL:       scheme + "://" + r.Host + uri,
+		Header:    header,
+		Truncated: truncated,
+	}
+	if len(body) == 0 {
+		return entry
+	}
+	if len(rl.BodyAllowedFields) > 0 {
+		filtered, ok := allowJSONFields(body, rl.BodyAllowedFields)
+		if !ok {
+			entry.Omitted = true
+			return entry
+		}
+		body = filtered
+	}
+	entry.Body = append([]byte(nil), rl.protectBody(body)...)
+	return entry
+}
+
+// replayAdmin serves captured requests on the admin API at
+// /request_logger/replay/{trace_id}. Access is controlled like the rest of
+// the admin API.
+type replayAdmin struct{}
+
+// CaddyModule returns the Caddy module information.
+func (replayAdmin) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "admin.api.request_logger",
+		New: func() caddy.Module { return new(replayAdmin) },
+	}
+}
+
+// Routes returns the route for the replay endpoint.
+func (ra replayAdmin) Routes() []caddy.AdminRoute {
+	return []caddy.AdminRoute{
+		{
+			Pattern: "/request_logger/replay/",
+			Handler: caddy.AdminHandlerFunc(ra.handleReplay),
+		},
+	}
+}
+
+// handleReplay returns a stored request as JSON, or as a curl command with
+// ?format=curl
+func (replayAdmin) handleReplay(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodGet {
+		return caddy.APIError{
+			HTTPStatus: http.StatusMethodNotAllowed,
+			Err:        fmt.Errorf("method not allowed"),
+		}
+	}
+
+	traceID := strings.TrimPrefix(r.URL.Path, "/request_logger/replay/")
+	entry, ok := replays.Get(traceID)
+	if !ok {
+		return caddy.APIError{
+			HTTPStatus: http.StatusNotFound,
+			Err:        fmt.Errorf("no stored request for trace ID %q", traceID),
+		}
+	}
+
+	if r.URL.Query().Get("format") == "curl" {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		_, err := w.Write([]byte(entry.curl()))
+		return err
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(entry)
+}
+
+// Interface guards
+var _ caddy.AdminRouter = (*replayAdmin)(nil)
@@ -0,0 +1,58 @@
+package request_logger
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestReplayEntryIsProtected(t *testing.T) {
+	rl := parseTest(t, `request_logger {
+		replay_store_size 10
+		redact_query_params token
+		deidentify_pipeline scrub_body_pii
+		tokenize_pii
+		pii_key secret
+	}`)
+	logs := provisionTest(t, rl)
+
+	r := httptest.NewRequest(http.MethodPost, "/orders?token=abc123&page=1", strings.NewReader(piiResponse))
+	r.Header.Set("Content-Type", "application/json")
+	r.Header.Set("Authorization", "Bearer s3cr3t")
+	r.Header.Set("Cookie", "session=s3cr3t")
+	serveTest(t, rl, r, http.StatusOK, "")
+
+	traceID, _ := logs.All()[0].ContextMap()["trace_id"].(string)
+	entry, ok := replays.Get(traceID)
+	if !ok {
+		t.Fatalf("no replay entry for trace ID %q", traceID)
+	}
+	stored := entry.curl()
+	for _, secret := range []string{"s3cr3t", "abc123", piiEmail, piiCard} {
+		if strings.Contains(stored, secret) {
+			t.Errorf("replay entry contains %q:\n%s", secret, stored)
+		}
+	}
+}
+
+func TestReplayStoreShrinksOnRelease(t *testing.T) {
+	s := &replayStore{sizes: make(map[int]int), entries: make(map[string]*replayEntry)}
+	s.Acquire(10)
+	for _, id := range []string{"a", "b", "c", "d", "e"} {
+		s.Put(id, &replayEntry{})
+	}
+
+	// A reload provisions the new, smaller config before cleaning up the old
+	s.Acquire(2)
+	s.Release(10)
+	if len(s.order) != 2 || len(s.entries) != 2 {
+		t.Fatalf("store kept %d entries after shrinking to 2", len(s.entries))
+	}
+	if _, ok := s.Get("e"); !ok {
+		t.Error("newest entry evicted")
+	}
+	if _, ok := s.Get("a"); ok {
+		t.Error("oldest entry kept")
+	}
+}
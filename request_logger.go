@@ -2,19 +2,44 @@ package request_logger
 
 import (
 	"bytes"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
+	"hash/fnv"
+	"math"
+	"math/rand"
+	"mime"
+	"net"
 	"net/http"
+	"net/netip"
+	pathpkg "path"
+	"regexp"
+	"runtime/debug"
+	"slices"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/caddyserver/caddy/v2"
 	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
 	"github.com/caddyserver/caddy/v2/caddyconfig/httpcaddyfile"
 	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"github.com/itchyny/gojq"
 	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// serverStart is when the first request_logger instance was provisioned,
+// which survives config reloads
+var (
+	serverStart     time.Time
+	serverStartOnce sync.Once
 )
 
 func init() {
@@ -39,7 +64,7 @@ func parseSize(sizeStr string) (int, error) {
 	// Extract number and unit
 	var num string
 	var unit string
-	
+
 	for i, char := range sizeStr {
 		if char >= '0' && char <= '9' || char == '.' {
 			num += string(char)
@@ -76,55 +101,566 @@ func parseSize(sizeStr string) (int, error) {
 	}
 }
 
+// minBase64Length is the shortest body considered for base64 detection,
+// so short plain words (e.g. "test") are not mistaken for base64
+const minBase64Length = 16
+
+// decodeBase64Body reports whether body looks like base64-encoded text and
+// returns the decoded bytes if so. The heuristic requires a minimum length,
+// a length divisible by 4, only standard or URL-safe base64 characters and
+// a successful decode.
+func decodeBase64Body(body []byte) ([]byte, bool) {
+	body = bytes.TrimSpace(body)
+	if len(body) < minBase64Length || len(body)%4 != 0 {
+		return nil, false
+	}
+
+	urlSafe := false
+	for i, c := range body {
+		switch {
+		case c >= 'A' && c <= 'Z', c >= 'a' && c <= 'z', c >= '0' && c <= '9':
+		case c == '+' || c == '/':
+		case c == '-' || c == '_':
+			urlSafe = true
+		case c == '=' && i >= len(body)-2:
+		default:
+			return nil, false
+		}
+	}
+
+	enc := base64.StdEncoding
+	if urlSafe {
+		enc = base64.URLEncoding
+	}
+	decoded, err := enc.DecodeString(string(body))
+	if err != nil {
+		return nil, false
+	}
+	return decoded, true
+}
+
+// shannonEntropy returns the Shannon entropy of data in bits per byte,
+// ranging from 0 (a single repeated byte) to 8 (uniformly random bytes).
+// Encrypted or compressed payloads typically score above 7.5.
+func shannonEntropy(data []byte) float64 {
+	if len(data) == 0 {
+		return 0
+	}
+
+	var counts [256]int
+	for _, b := range data {
+		counts[b]++
+	}
+
+	var entropy float64
+	total := float64(len(data))
+	for _, c := range counts {
+		if c == 0 {
+			continue
+		}
+		p := float64(c) / total
+		entropy -= p * math.Log2(p)
+	}
+	return entropy
+}
+
 // parseCaddyfile parses the Caddyfile configuration for request_logger
 func parseCaddyfile(h httpcaddyfile.Helper) (caddyhttp.MiddlewareHandler, error) {
 	var rl RequestLogger
-	
+
 	// Parse the Caddyfile configuration
 	err := rl.UnmarshalCaddyfile(h.Dispenser)
 	if err != nil {
 		return nil, err
 	}
-	
+
 	return &rl, nil
 }
 
 // RequestLogger implements an HTTP middleware that logs request details
 type RequestLogger struct {
-	// Logger name for structured logging
+	// Logger name for structured logging. The name is fixed when the module
+	// is provisioned; a name with placeholders such as {http.request.host}
+	// is resolved per request and logged as logger_name instead.
 	LoggerName string `json:"logger_name,omitempty"`
-	
+
+	// Fields added to every entry; values may contain request placeholders,
+	// e.g. {http.request.header.X-Tenant}
+	ExtraFields map[string]string `json:"extra_fields,omitempty"`
+
 	// Log level: debug, info, warn, error
 	LogLevel string `json:"log_level,omitempty"`
-	
+
+	// Log level per response status code or class, e.g. 5xx error; statuses
+	// that match nothing log at log_level
+	LevelByStatus map[string]string `json:"level_by_status,omitempty"`
+
+	// Field layout: default, or caddy to match Caddy's native access logs
+	OutputFormat string `json:"output_format,omitempty"`
+
+	// Format of the timestamp field: rfc3339, unix, unix_milli or a Go
+	// reference layout; by default it is left to the encoder
+	TimestampFormat string `json:"timestamp_format,omitempty"`
+
 	// Include request body in logs
 	IncludeRequestBody bool `json:"include_request_body,omitempty"`
-	
+
+	// Log the request body only when the response has one of these status
+	// codes, e.g. 422 and 500
+	BodyForStatuses []int `json:"body_for_statuses,omitempty"`
+
+	// Log how long the downstream handlers took as duration
+	LogTiming bool `json:"log_timing,omitempty"`
+
+	// Only log requests that took at least this long
+	MinDuration caddy.Duration `json:"min_duration,omitempty"`
+
+	// Log the response status and size, after the response was written
+	IncludeResponse bool `json:"include_response,omitempty"`
+
+	// Log the response status class, e.g. 2xx or 5xx
+	LogStatusClass bool `json:"log_status_class,omitempty"`
+
 	// Include all request headers in logs
 	IncludeAllHeaders bool `json:"include_all_headers,omitempty"`
-	
+
 	// Maximum body size to log (in bytes)
 	MaxBodySize int `json:"max_body_size,omitempty"`
-	
+
+	// Maximum number of lines logged for text bodies (0 disables)
+	MaxBodyLines int `json:"max_body_lines,omitempty"`
+
+	// Log at warn with large_body when the body exceeds this size (in bytes)
+	LargeBodyThreshold int `json:"large_body_threshold,omitempty"`
+
+	// Flag GET, HEAD and DELETE requests carrying a body, even if their
+	// content type is skipped
+	LogUnexpectedBody bool `json:"log_unexpected_body,omitempty"`
+
+	// Flag bodies whose length differs from the declared Content-Length
+	DetectLengthMismatch bool `json:"detect_length_mismatch,omitempty"`
+
+	// Log the time from handler entry until the captured body was read
+	LogReadTiming bool `json:"log_read_timing,omitempty"`
+
+	// For bodies larger than max_body_size, log this many bytes sampled from
+	// the head, middle and tail instead of only the head
+	BodySampleBytes int `json:"body_sample_bytes,omitempty"`
+
+	// Flag requests whose body the handler left unread between reads for at
+	// least backend_read_stall_threshold
+	LogBackendReadStall bool `json:"log_backend_read_stall,omitempty"`
+
+	// Pause between body reads reported as a stall (default 1s)
+	BackendReadStallThreshold caddy.Duration `json:"backend_read_stall_threshold,omitempty"`
+
+	// Maximum time to wait for the body to be captured (0 waits indefinitely)
+	BodyReadTimeout caddy.Duration `json:"body_read_timeout,omitempty"`
+
 	// Skip logging for specific methods
 	SkipMethods []string `json:"skip_methods,omitempty"`
-	
+
+	// Skip CORS preflight requests, leaving other OPTIONS requests logged
+	SkipPreflight bool `json:"skip_preflight,omitempty"`
+
+	// Skip logging for response status codes or classes, e.g. 304 2xx
+	SkipStatus []string `json:"skip_status,omitempty"`
+
 	// Skip logging for specific paths
 	SkipPaths []string `json:"skip_paths,omitempty"`
-	
+
+	// Skip logging for paths matching these regular expressions
+	SkipPathsRegex []string `json:"skip_paths_regex,omitempty"`
+
+	// Skip logging for paths matching these glob patterns, e.g. /static/*
+	SkipPathsGlob []string `json:"skip_paths_glob,omitempty"`
+
 	// Specific headers to include in logs (if not include_all_headers)
 	IncludeHeaders []string `json:"include_headers,omitempty"`
-	
+
 	// Headers to exclude from logging (when include_all_headers is true)
 	ExcludeHeaders []string `json:"exclude_headers,omitempty"`
-	
+
+	// Query parameters logged with their values masked, e.g. access_token
+	RedactQueryParams []string `json:"redact_query_params,omitempty"`
+
+	// Headers logged with their values masked, e.g. Authorization or Cookie
+	RedactHeaders []string `json:"redact_headers,omitempty"`
+
+	// Log headers as a JSON string with sorted names, so identical header
+	// sets produce identical output
+	StableHeaderOrder bool `json:"stable_header_order,omitempty"`
+
 	// Skip logging for specific content types
 	SkipContentTypes []string `json:"skip_content_types,omitempty"`
-	
+
+	// Fraction of requests to log, between 0 and 1 (default 1); 0 logs
+	// nothing
+	SampleRate *float64 `json:"sample_rate,omitempty"`
+
+	// Sample requests to keep the log rate near target_logs_per_sec
+	AdaptiveSampling bool `json:"adaptive_sampling,omitempty"`
+
+	// Log requests that were sampled out anyway if they end in a 4xx or
+	// 5xx response or a handler error
+	KeepErrors bool `json:"keep_errors,omitempty"`
+
+	// Target number of logged requests per second for adaptive_sampling
+	TargetLogsPerSec float64 `json:"target_logs_per_sec,omitempty"`
+
+	// Honor the sampling decision in the sampling header of incoming requests
+	// and report whether the request was logged in the same header on the
+	// request passed downstream and on the response
+	PropagateSamplingDecision bool `json:"propagate_sampling_decision,omitempty"`
+
+	// Header carrying the sampling decision as 1 or 0 (default X-Sampled)
+	SamplingHeader string `json:"sampling_header,omitempty"`
+
+	// Emit a minimal debug entry with the reason instead of silently skipping
+	LogSkipReason bool `json:"log_skip_reason,omitempty"`
+
+	// Include response body in logs, up to max_response_body_size
+	IncludeResponseBody bool `json:"include_response_body,omitempty"`
+
+	// Maximum response body size to log (default max_body_size)
+	MaxResponseBodySize int `json:"max_response_body_size,omitempty"`
+
 	// Base64 encode request body (useful for binary data)
 	Base64EncodeBody bool `json:"base64_encode_body,omitempty"`
-	
+
+	// Log text bodies raw and binary bodies as base64, decided per body
+	AutoBodyEncoding bool `json:"auto_body_encoding,omitempty"`
+
+	// Only log these JSON body fields (dot paths); other bodies are omitted
+	BodyAllowedFields []string `json:"body_allowed_fields,omitempty"`
+
+	// Bytes of request bodies logged per second across all endpoints.
+	// Bodies of endpoints over their share are skipped; 0 disables.
+	BodyLogBudgetBytesPerSec int `json:"body_log_budget_bytes_per_sec,omitempty"`
+
+	// Top-level fields extracted from JSON bodies, mapping the field name to
+	// a dot path, e.g. order_id order.id
+	BodyContextFields map[string]string `json:"body_context_fields,omitempty"`
+
+	// Log only these JSON body values (dot paths) as a json_fields map
+	LogJSONFields []string `json:"log_json_fields,omitempty"`
+
+	// jq filter applied to JSON bodies before logging, e.g. {id: .user.id}
+	BodyJQFilter string `json:"body_jq_filter,omitempty"`
+
+	// Log a hex digest of the body as request_body_hash: sha256 or md5.
+	// The digest covers what was captured up to max_body_size.
+	HashBody string `json:"hash_body,omitempty"`
+
+	// Digest the whole body as the handler reads it instead of the capture,
+	// without holding it in memory
+	HashFullBody bool `json:"hash_full_body,omitempty"`
+
+	// Body handling per content type pattern: raw, hash, skip or base64
+	BodySinkByType map[string]string `json:"body_sink_by_type,omitempty"`
+
+	// Upload captured bodies to this store and log a reference instead of the
+	// body itself (file:///dir or an http(s):// upload endpoint)
+	ArtifactStore string `json:"artifact_store,omitempty"`
+
+	// Replace emails, card and phone numbers in the body and sensitive_fields
+	// with stable HMAC tokens (requires pii_key)
+	TokenizePII bool `json:"tokenize_pii,omitempty"`
+
+	// Secret HMAC key for PII tokens; supports {env.*} placeholders
+	PIIKey string `json:"pii_key,omitempty"`
+
+	// De-identification steps run over every entry in this order:
+	// anonymize_ip, redact_headers, scrub_body_pii, clean_query
+	DeidentifyPipeline []string `json:"deidentify_pipeline,omitempty"`
+
+	// Redaction strategy per log field, header:<name> or body:<path>: mask,
+	// partial, hash, tokenize (requires pii_key) or remove
+	RedactionStrategies map[string]string `json:"redaction_strategies,omitempty"`
+
+	// Log fields treated as sensitive, e.g. query or referer
+	SensitiveFields []string `json:"sensitive_fields,omitempty"`
+
+	// Name of a registered Redactor applied to the body and sensitive_fields
+	RedactorName string `json:"redactor,omitempty"`
+
+	// Detect bodies that are already base64 encoded and avoid encoding them twice
+	DetectBase64 bool `json:"detect_base64,omitempty"`
+
+	// Decompress gzip, deflate and br encoded bodies (up to max_body_size)
+	// before logging them; the handler still receives the original body
+	DecodeBody bool `json:"decode_body,omitempty"`
+
+	// Also log the decoded contents of detected base64 bodies
+	DecodeBase64Body bool `json:"decode_base64_body,omitempty"`
+
+	// Log the Shannon entropy of the captured body (up to max_body_size) and query
+	LogEntropy bool `json:"log_entropy,omitempty"`
+
+	// Derive client_ip from X-Forwarded-For or X-Real-IP
+	TrustForwarded bool `json:"trust_forwarded,omitempty"`
+
+	// Proxies (CIDR) whose forwarding headers are trusted; required by trust_forwarded
+	TrustedProxies []string `json:"trusted_proxies,omitempty"`
+
+	// Client IP ranges (CIDR) whose addresses are logged masked to /24 (IPv4)
+	// or /48 (IPv6); other addresses are logged in full
+	MaskIPRanges []string `json:"mask_ip_ranges,omitempty"`
+
+	// Log how many requests the client IP made within client_count_window
+	LogClientRequestCount bool `json:"log_client_request_count,omitempty"`
+
+	// Window for per-client request counts (default 1m)
+	ClientCountWindow caddy.Duration `json:"client_count_window,omitempty"`
+
+	// Maximum number of client IPs tracked at once (default 10000)
+	ClientCountMaxEntries int `json:"client_count_max_entries,omitempty"`
+
+	// Log the position of the request on its keep-alive connection
+	LogConnectionRequestIndex bool `json:"log_connection_request_index,omitempty"`
+
+	// Log the TLS version, cipher suite and SNI server name of HTTPS requests,
+	// and the client certificate of mutual TLS connections
+	IncludeTLS bool `json:"include_tls,omitempty"`
+
+	// Flag requests whose HTTP version differs from the ALPN-negotiated protocol
+	DetectProtocolAnomaly bool `json:"detect_protocol_anomaly,omitempty"`
+
+	// Flag requests arriving while the request rate exceeds its moving average
+	// by rate_anomaly_multiplier
+	DetectRateAnomaly bool `json:"detect_rate_anomaly,omitempty"`
+
+	// Multiple of the baseline rate considered anomalous (default 3)
+	RateAnomalyMultiplier float64 `json:"rate_anomaly_multiplier,omitempty"`
+
+	// Log the QUIC details available for HTTP/3 requests (0-RTT, local address)
+	LogHTTP3 bool `json:"log_http3,omitempty"`
+
+	// Log how long after server start the request arrived
+	LogUptime bool `json:"log_uptime,omitempty"`
+
+	// Log a weighted risk score computed from suspicious request signals
+	ComputeRiskScore bool `json:"compute_risk_score,omitempty"`
+
+	// Weight per risk signal (honeypot_path, high_entropy,
+	// suspicious_user_agent, missing_headers)
+	RiskWeights map[string]int `json:"risk_weights,omitempty"`
+
+	// Path prefixes counted as the honeypot_path risk signal
+	HoneypotPaths []string `json:"honeypot_paths,omitempty"`
+
+	// Name of a registered AnomalyScorer whose score is logged as anomaly_score
+	AnomalyScorerName string `json:"anomaly_scorer,omitempty"`
+
+	// Log requests scoring above this at warn (0 disables)
+	AnomalyThreshold float64 `json:"anomaly_threshold,omitempty"`
+
+	// Request signatures (hex SHA-256 of method, path and body joined by NUL
+	// bytes) logged at warn with denylisted
+	SignatureDenylist []string `json:"signature_denylist,omitempty"`
+
+	// Respond 403 to denylisted requests instead of calling the next handler
+	BlockDenylisted bool `json:"block_denylisted,omitempty"`
+
+	// Placeholder holding the authenticated user set by a preceding auth
+	// handler, e.g. {http.auth.user.id}
+	AuthResultVar string `json:"auth_result_var,omitempty"`
+
+	// Generate a trace_id for every logged request
+	GenerateTraceID bool `json:"generate_trace_id,omitempty"`
+
+	// Format of generated trace IDs: hex (default), uuid, ulid or ksuid
+	TraceIDFormat string `json:"trace_id_format,omitempty"`
+
+	// Header carrying the request ID, logged as request_id (default
+	// X-Request-ID when generate_request_id is set)
+	RequestIDHeader string `json:"request_id_header,omitempty"`
+
+	// Generate a UUID request ID when the request has none and set it on
+	// the request and response headers
+	GenerateRequestID bool `json:"generate_request_id,omitempty"`
+
+	// Keep this many recent requests (with headers and captured body) for
+	// replay through the admin API, keyed by trace_id
+	ReplayStoreSize int `json:"replay_store_size,omitempty"`
+
+	// Record the log fields as an event on the active OpenTelemetry span
+	AttachToSpan bool `json:"attach_to_span,omitempty"`
+
+	// Skip the regular log entry when the fields were attached to a span
+	SpanOnly bool `json:"span_only,omitempty"`
+
+	// Header carrying the client-reported timezone, e.g. X-Timezone
+	TimezoneHeader string `json:"timezone_header,omitempty"`
+
+	// Cookie carrying the client-reported timezone, used if the header is absent
+	TimezoneCookie string `json:"timezone_cookie,omitempty"`
+
+	// Header carrying the A/B test variant, logged as experiment_variant
+	ExperimentHeader string `json:"experiment_header,omitempty"`
+
+	// Cookie carrying the A/B test variant, used if the header is absent
+	ExperimentCookie string `json:"experiment_cookie,omitempty"`
+
+	// Variants to assign deterministically by client IP when the request
+	// carries none, e.g. control treatment
+	ExperimentVariants []string `json:"experiment_variants,omitempty"`
+
+	// Log the hops of the RFC 7239 Forwarded header under forwarded
+	ParseForwardedHeader bool `json:"parse_forwarded_header,omitempty"`
+
+	// Log the client's preferred locale from Accept-Language
+	LogLocale bool `json:"log_locale,omitempty"`
+
+	// Static build metadata added to every entry, e.g. version or commit
+	BuildInfo map[string]string `json:"build_info,omitempty"`
+
+	// Add VCS revision, time and Go version read from the binary's build info
+	IncludeBuildInfo bool `json:"include_build_info,omitempty"`
+
+	// Log 3xx responses with their Location, at info level or above
+	LogRedirects bool `json:"log_redirects,omitempty"`
+
+	// Request var holding the rate limit zone a preceding handler assigned
+	RateLimitZoneVar string `json:"rate_limit_zone_var,omitempty"`
+
+	// Request var holding the logical name of the service the request was
+	// routed to, logged as downstream_service
+	DownstreamServiceVar string `json:"downstream_service_var,omitempty"`
+
+	// Request var holding the feature flag snapshot a flag evaluation
+	// handler stored for the request, logged as feature_flags
+	FeatureFlagVar string `json:"feature_flag_var,omitempty"`
+
+	// Request vars (set e.g. with the vars directive) to log under context
+	ContextKeys []string `json:"context_keys,omitempty"`
+
+	// Log the hash of the request's cache key as cache_key
+	LogCacheKey bool `json:"log_cache_key,omitempty"`
+
+	// Cache key layout using {method}, {host}, {path}, {query} and {vary}
+	CacheKeyTemplate string `json:"cache_key_template,omitempty"`
+
+	// Request headers that make up {vary} in the cache key
+	CacheKeyVary []string `json:"cache_key_vary,omitempty"`
+
+	// Log bytes and duration of streamed responses such as server-sent events
+	LogStreamingStats bool `json:"log_streaming_stats,omitempty"`
+
+	// Log the served media type and whether the request's Accept header
+	// allowed it, i.e. whether 406 Not Acceptable would have been due
+	LogContentNegotiation bool `json:"log_content_negotiation,omitempty"`
+
+	// Also emit an APM transaction entry (Elastic APM/Datadog field names)
+	// for every completed request
+	APMFormat bool `json:"apm_format,omitempty"`
+
+	// Log the time left before the client's deadline (X-Request-Timeout or
+	// the request context) when the response completes
+	LogDeadlineMargin bool `json:"log_deadline_margin,omitempty"`
+
+	// Classify failed requests as timeout, auth_failure, client_error or
+	// server_error, logged as error_class
+	ClassifyErrors bool `json:"classify_errors,omitempty"`
+
+	// Log the reverse proxy upstream that handled the request
+	LogUpstream bool `json:"log_upstream,omitempty"`
+
+	// Header identifying the tenant, logged as tenant, e.g. X-Tenant-ID
+	TenantHeader string `json:"tenant_header,omitempty"`
+
+	// Additional destinations, each with its own encoding, that receive
+	// every entry alongside the regular logger
+	MultiFormat []FormatSink `json:"multi_format,omitempty"`
+
+	// Log file per tenant; other tenants use the regular logger
+	TenantSinks map[string]string `json:"tenant_sinks,omitempty"`
+
+	// Retention hint logged as retention, e.g. 30d
+	RetentionClass string `json:"retention_class,omitempty"`
+
+	// Retention classes for matching paths or statuses; the first match wins
+	// over retention_class
+	RetentionRules []RetentionRule `json:"retention_rules,omitempty"`
+
+	// Rules assigning a cost to requests, logged as request_cost
+	RequestCost []CostRule `json:"request_cost,omitempty"`
+
+	// Settings for requests matching a path pattern; the first match
+	// replaces the settings of this logger
+	PathOverrides []PathOverride `json:"path_overrides,omitempty"`
+
+	// Flag requests that reuse a nonce or carry a stale timestamp
+	ReplayDetection *ReplayDetection `json:"replay_detection,omitempty"`
+
+	// Compression of log files written by the module itself: none, gzip or
+	// zstd
+	CompressOutput string `json:"compress_output,omitempty"`
+
+	// Endpoint to POST every entry to as JSON, in addition to the logger
+	WebhookURL string `json:"webhook_url,omitempty"`
+
+	// Timeout of a webhook request (default 5s)
+	WebhookTimeout caddy.Duration `json:"webhook_timeout,omitempty"`
+
+	// Number of entries buffered for the webhook before new entries are
+	// dropped (default 1024)
+	WebhookBufferSize int `json:"webhook_buffer_size,omitempty"`
+
+	// File to write entries to instead of Caddy's logger, rotated with
+	// max_size, max_age and max_backups
+	OutputFile string `json:"output_file,omitempty"`
+
+	// Size in bytes at which output_file is rotated (default 100MB)
+	MaxSize int `json:"max_size,omitempty"`
+
+	// How long rotated output files are kept (0 keeps them regardless of age)
+	MaxAge caddy.Duration `json:"max_age,omitempty"`
+
+	// Number of rotated output files to keep (0 keeps all)
+	MaxBackups int `json:"max_backups,omitempty"`
+
+	// Convert fields to the type a strict consumer expects: string, int,
+	// float or bool, e.g. content_length string
+	FieldTypes map[string]string `json:"field_types,omitempty"`
+
+	// Merge identical requests within this window into one entry with a count
+	CoalesceWindow caddy.Duration `json:"coalesce_window,omitempty"`
+
+	// Interval between heartbeat log entries, emitted even when idle (0 disables)
+	HeartbeatInterval caddy.Duration `json:"heartbeat_interval,omitempty"`
+
 	logger *zap.Logger
+
+	clientCounter  *windowCounter
+	connCounter    *windowCounter
+	rateBaseline   *rateBaseline
+	sampler        *adaptiveSampler
+	bodyBudget     *bodyBudget
+	tokenizer      *piiTokenizer
+	strategies     *redactionStrategies
+	redactor       Redactor
+	scorer         AnomalyScorer
+	jqFilter       *gojq.Code
+	coalescer      *coalescer
+	buildInfo      map[string]string
+	maskRanges     []netip.Prefix
+	trustedProxies []netip.Prefix
+	skipPathRes    []*regexp.Regexp
+	denylist       map[string]struct{}
+	artifacts      *artifactUploader
+	tenants        *tenantSinks
+	formats        *formatSinks
+	output         *fileSink
+	webhook        *webhookSink
+
+	// replay_store_size registered with the shared replay store
+	replaySize int
+
+	// Requests seen since the last heartbeat
+	heartbeatRequests int64
+	stopHeartbeat     chan struct{}
+	heartbeatDone     chan struct{}
 }
 
 // CaddyModule returns the module information.
@@ -147,149 +683,1841 @@ func (rl *RequestLogger) Provision(ctx caddy.Context) error {
 	if rl.MaxBodySize == 0 {
 		rl.MaxBodySize = 1024 * 1024 // 1MB default
 	}
-	
-	// Get logger
-	rl.logger = ctx.Logger(rl)
-	
-	return nil
-}
-
-// shouldSkipMethod checks if the request method should be skipped
-func (rl *RequestLogger) shouldSkipMethod(method string) bool {
-	for _, skipMethod := range rl.SkipMethods {
-		if strings.EqualFold(method, skipMethod) {
-			return true
-		}
+	if rl.MaxResponseBodySize == 0 {
+		rl.MaxResponseBodySize = rl.MaxBodySize
 	}
-	return false
-}
 
-// shouldSkipPath checks if the request path should be skipped
-func (rl *RequestLogger) shouldSkipPath(path string) bool {
-	for _, skipPath := range rl.SkipPaths {
-		if strings.Contains(path, skipPath) {
-			return true
-		}
+	if rl.ReplayStoreSize > 0 {
+		// Stored requests are looked up by trace ID
+		rl.GenerateTraceID = true
+	}
+	if rl.GenerateRequestID && rl.RequestIDHeader == "" {
+		rl.RequestIDHeader = "X-Request-ID"
+	}
+	if rl.TraceIDFormat == "" {
+		rl.TraceIDFormat = idFormatHex
+	}
+	if rl.ClientCountWindow == 0 {
+		rl.ClientCountWindow = caddy.Duration(time.Minute)
+	}
+	if rl.ClientCountMaxEntries == 0 {
+		rl.ClientCountMaxEntries = 10000
+	}
+	if rl.CacheKeyTemplate == "" {
+		rl.CacheKeyTemplate = defaultCacheKeyTemplate
+	}
+	if rl.BackendReadStallThreshold == 0 {
+		rl.BackendReadStallThreshold = caddy.Duration(time.Second)
+	}
+	if rl.SampleRate == nil {
+		rate := 1.0
+		rl.SampleRate = &rate
+	}
+	if rl.SamplingHeader == "" {
+		rl.SamplingHeader = "X-Sampled"
+	}
+	if rl.RateAnomalyMultiplier == 0 {
+		rl.RateAnomalyMultiplier = 3
 	}
-	return false
-}
 
-// shouldSkipContentType checks if the request content type should be skipped
-func (rl *RequestLogger) shouldSkipContentType(contentType string) bool {
-	for _, skipType := range rl.SkipContentTypes {
-		if strings.Contains(strings.ToLower(contentType), strings.ToLower(skipType)) {
-			return true
+	// Get logger
+	if rl.OutputFile != "" {
+		if rl.MaxSize < 0 || rl.MaxAge < 0 || rl.MaxBackups < 0 {
+			return fmt.Errorf("output_file rotation settings must not be negative")
 		}
+		rl.output = openRotatingSink(rl.OutputFile, rl.staticLoggerName(), rl.MaxSize, time.Duration(rl.MaxAge), rl.MaxBackups)
+		rl.logger = rl.output.logger
+	} else {
+		rl.logger = ctx.Logger(rl)
 	}
-	return false
-}
 
-// isHeaderExcluded checks if a header should be excluded from logging
-func (rl *RequestLogger) isHeaderExcluded(headerName string) bool {
-	for _, excludeHeader := range rl.ExcludeHeaders {
-		if strings.EqualFold(headerName, excludeHeader) {
-			return true
+	for _, pattern := range rl.SkipPathsRegex {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return fmt.Errorf("invalid skip_paths_regex %q: %v", pattern, err)
 		}
+		rl.skipPathRes = append(rl.skipPathRes, re)
 	}
-	return false
-}
 
-// ServeHTTP implements the middleware interface
-func (rl *RequestLogger) ServeHTTP(w http.ResponseWriter, r *http.Request, next caddyhttp.Handler) error {
-	// Check if we should skip logging for this method
-	if rl.shouldSkipMethod(r.Method) {
-		return next.ServeHTTP(w, r)
+	if rl.HashFullBody && rl.HashBody == "" {
+		rl.HashBody = hashSHA256
 	}
-	
-	// Check if we should skip logging for this path
-	if rl.shouldSkipPath(r.URL.Path) {
-		return next.ServeHTTP(w, r)
+	switch rl.HashBody {
+	case "", hashSHA256, hashMD5:
+	default:
+		return fmt.Errorf("invalid hash_body algorithm %q (expected sha256 or md5)", rl.HashBody)
 	}
-	
-	// Check if we should skip logging for this content type
-	contentType := r.Header.Get("Content-Type")
-	if rl.shouldSkipContentType(contentType) {
-		return next.ServeHTTP(w, r)
+
+	if rl.RedactorName != "" {
+		redactor, err := lookupRedactor(rl.RedactorName)
+		if err != nil {
+			return err
+		}
+		rl.redactor = redactor
 	}
-	
-	start := time.Now()
-	
-	// Read request body if needed
-	var requestBody []byte
-	if rl.IncludeRequestBody && r.Body != nil {
-		requestBody, _ = io.ReadAll(io.LimitReader(r.Body, int64(rl.MaxBodySize)))
-		r.Body = io.NopCloser(bytes.NewBuffer(requestBody))
+
+	if rl.AnomalyScorerName != "" {
+		scorer, err := lookupAnomalyScorer(rl.AnomalyScorerName)
+		if err != nil {
+			return err
+		}
+		rl.scorer = scorer
 	}
-	
-	// Prepare log fields
-	fields := []zap.Field{
-		zap.String("method", r.Method),
-		zap.String("path", r.URL.Path),
-		zap.String("query", r.URL.RawQuery),
-		zap.String("remote_addr", r.RemoteAddr),
-		zap.String("user_agent", r.UserAgent()),
-		zap.String("referer", r.Referer()),
-		zap.String("host", r.Host),
-		zap.String("proto", r.Proto),
-		zap.String("content_type", contentType),
-		zap.Int64("content_length", r.ContentLength),
-		zap.Time("timestamp", start),
-	}
-	
-	// Add request headers
-	if rl.IncludeAllHeaders {
-		headers := make(map[string][]string)
-		for name, values := range r.Header {
-			if !rl.isHeaderExcluded(name) {
-				headers[name] = values
-			}
+
+	if rl.TokenizePII {
+		key := caddy.NewReplacer().ReplaceAll(rl.PIIKey, "")
+		if key == "" {
+			return fmt.Errorf("tokenize_pii requires pii_key")
 		}
-		if len(headers) > 0 {
-			fields = append(fields, zap.Any("headers", headers))
+		rl.tokenizer = &piiTokenizer{key: []byte(key)}
+	}
+
+	if len(rl.RedactionStrategies) > 0 {
+		strategies, err := newRedactionStrategies(rl.RedactionStrategies, caddy.NewReplacer().ReplaceAll(rl.PIIKey, ""))
+		if err != nil {
+			return err
 		}
-	} else if len(rl.IncludeHeaders) > 0 {
-		headers := make(map[string]string)
-		for _, headerName := range rl.IncludeHeaders {
-			if value := r.Header.Get(headerName); value != "" {
-				headers[headerName] = value
-			}
+		rl.strategies = strategies
+	}
+
+	if rl.BodyJQFilter != "" {
+		code, err := compileJQ(rl.BodyJQFilter)
+		if err != nil {
+			return err
 		}
-		if len(headers) > 0 {
-			fields = append(fields, zap.Any("headers", headers))
+		rl.jqFilter = code
+	}
+
+	if len(rl.TrustedProxies) > 0 {
+		proxies, err := parseIPRanges(rl.TrustedProxies)
+		if err != nil {
+			return err
 		}
+		rl.trustedProxies = proxies
 	}
-	
-	// Add request body if included
-	if rl.IncludeRequestBody && len(requestBody) > 0 {
-		if rl.Base64EncodeBody {
-			encoded := base64.StdEncoding.EncodeToString(requestBody)
-			fields = append(fields, zap.String("request_body_b64", encoded))
-		} else {
-			fields = append(fields, zap.ByteString("request_body", requestBody))
+
+	if len(rl.MaskIPRanges) > 0 {
+		ranges, err := parseIPRanges(rl.MaskIPRanges)
+		if err != nil {
+			return err
 		}
+		rl.maskRanges = ranges
 	}
-	
-	// Log the request
-	message := fmt.Sprintf("Request: %s %s", r.Method, r.URL.Path)
-	
-	switch rl.LogLevel {
-	case "debug":
-		rl.logger.Debug(message, fields...)
-	case "info":
-		rl.logger.Info(message, fields...)
-	case "warn":
-		rl.logger.Warn(message, fields...)
-	case "error":
-		rl.logger.Error(message, fields...)
-	default:
-		rl.logger.Info(message, fields...)
+
+	if len(rl.SignatureDenylist) > 0 {
+		rl.denylist = newSignatureSet(rl.SignatureDenylist)
 	}
-	
-	// Call next handler
-	return next.ServeHTTP(w, r)
-}
 
-// UnmarshalCaddyfile implements caddyfile.Unmarshaler.
+	if err := validateFieldTypes(rl.FieldTypes); err != nil {
+		return err
+	}
+	if err := validateDeidentifyPipeline(rl.DeidentifyPipeline); err != nil {
+		return err
+	}
+	if err := validateRiskWeights(rl.RiskWeights); err != nil {
+		return err
+	}
+	if err := validateRetentionRules(rl.RetentionRules); err != nil {
+		return err
+	}
+
+	serverStartOnce.Do(func() { serverStart = time.Now() })
+	initMetrics()
+
+	if rl.ArtifactStore != "" {
+		store, err := newArtifactStore(rl.ArtifactStore)
+		if err != nil {
+			return err
+		}
+		rl.artifacts = newArtifactUploader(store, rl.logger)
+	}
+
+	if rl.ReplayStoreSize > 0 {
+		replays.Acquire(rl.ReplayStoreSize)
+		rl.replaySize = rl.ReplayStoreSize
+	}
+
+	if rl.LogClientRequestCount {
+		rl.clientCounter = newWindowCounter(time.Duration(rl.ClientCountWindow), rl.ClientCountMaxEntries)
+	}
+
+	if rl.LogConnectionRequestIndex {
+		rl.connCounter = newIdleCounter(connIdleTimeout, rl.ClientCountMaxEntries)
+	}
+
+	if rl.RateAnomalyMultiplier <= 1 {
+		return fmt.Errorf("rate_anomaly_multiplier must be greater than 1")
+	}
+	if rl.DetectRateAnomaly {
+		rl.rateBaseline = new(rateBaseline)
+	}
+
+	if rl.BodyLogBudgetBytesPerSec < 0 {
+		return fmt.Errorf("body_log_budget must not be negative")
+	}
+	if rl.BodyLogBudgetBytesPerSec > 0 {
+		rl.bodyBudget = newBodyBudget(rl.BodyLogBudgetBytesPerSec)
+	}
+
+	if rl.AdaptiveSampling {
+		if rl.TargetLogsPerSec <= 0 {
+			return fmt.Errorf("adaptive_sampling requires a positive target_logs_per_sec")
+		}
+		rl.sampler = newAdaptiveSampler(rl.TargetLogsPerSec)
+	}
+
+	if !validCompression(rl.CompressOutput) {
+		return fmt.Errorf("invalid compress_output %q (expected none, gzip or zstd)", rl.CompressOutput)
+	}
+
+	if len(rl.MultiFormat) > 0 {
+		if err := validateFormatSinks(rl.MultiFormat); err != nil {
+			return err
+		}
+		formats, err := openFormatSinks(rl.MultiFormat, rl.CompressOutput)
+		if err != nil {
+			return err
+		}
+		rl.formats = formats
+		rl.logger = formats.Tee(rl.logger)
+	}
+
+	if rl.WebhookURL != "" {
+		if rl.WebhookTimeout == 0 {
+			rl.WebhookTimeout = caddy.Duration(5 * time.Second)
+		}
+		if rl.WebhookBufferSize == 0 {
+			rl.WebhookBufferSize = 1024
+		}
+		if rl.WebhookTimeout < 0 || rl.WebhookBufferSize < 0 {
+			return fmt.Errorf("webhook_timeout and webhook_buffer_size must not be negative")
+		}
+		webhook, err := newWebhookSink(rl.WebhookURL, time.Duration(rl.WebhookTimeout), rl.WebhookBufferSize, rl.logger)
+		if err != nil {
+			return err
+		}
+		rl.webhook = webhook
+		rl.logger = webhook.Tee(rl.logger)
+	}
+
+	if rl.ReplayDetection != nil {
+		if err := rl.ReplayDetection.provision(); err != nil {
+			return err
+		}
+	}
+
+	if err := validateCostRules(rl.RequestCost); err != nil {
+		return err
+	}
+
+	if err := rl.provisionPathOverrides(ctx); err != nil {
+		return err
+	}
+
+	if len(rl.TenantSinks) > 0 {
+		if rl.TenantHeader == "" {
+			return fmt.Errorf("tenant_sinks requires tenant_header")
+		}
+		tenants, err := newTenantSinks(rl.TenantSinks, rl.staticLoggerName(), rl.CompressOutput, rl.teeSinks)
+		if err != nil {
+			return err
+		}
+		rl.tenants = tenants
+	}
+
+	if rl.CoalesceWindow > 0 {
+		rl.coalescer = newCoalescer(time.Duration(rl.CoalesceWindow))
+	}
+
+	rl.buildInfo = rl.collectBuildInfo()
+
+	// Start heartbeat
+	if rl.HeartbeatInterval > 0 {
+		rl.stopHeartbeat = make(chan struct{})
+		rl.heartbeatDone = make(chan struct{})
+		go rl.runHeartbeat(time.Duration(rl.HeartbeatInterval), rl.stopHeartbeat, rl.heartbeatDone)
+	}
+
+	return nil
+}
+
+// Validate checks settings that Provision does not need to act on, so
+// misconfigurations fail at startup instead of silently falling back to
+// defaults. Patterns compiled by Provision, such as skip_paths_regex, are
+// checked there.
+func (rl *RequestLogger) Validate() error {
+	if _, ok := parseLevel(rl.LogLevel); !ok {
+		return fmt.Errorf("invalid log_level %q (expected debug, info, warn or error)", rl.LogLevel)
+	}
+	if rl.MaxBodySize < 0 {
+		return fmt.Errorf("max_body_size must not be negative")
+	}
+	if rl.MaxResponseBodySize < 0 {
+		return fmt.Errorf("max_response_body_size must not be negative")
+	}
+	// Without trusted proxies any client could set its own client_ip
+	if rl.TrustForwarded && len(rl.TrustedProxies) == 0 {
+		return fmt.Errorf("trust_forwarded requires trusted_proxies")
+	}
+
+	if rl.SampleRate != nil && (*rl.SampleRate < 0 || *rl.SampleRate > 1) {
+		return fmt.Errorf("sample_rate must be between 0 and 1, got %v", *rl.SampleRate)
+	}
+
+	for _, pattern := range rl.SkipPathsGlob {
+		if _, err := pathpkg.Match(pattern, "/"); err != nil {
+			return fmt.Errorf("invalid skip_paths_glob %q: %v", pattern, err)
+		}
+	}
+
+	for _, code := range rl.BodyForStatuses {
+		if code < 100 || code > 599 {
+			return fmt.Errorf("invalid body_for_statuses status code %d", code)
+		}
+	}
+
+	for pattern, mode := range rl.BodySinkByType {
+		switch mode {
+		case bodyModeRaw, bodyModeBase64, bodyModeHash, bodyModeSkip:
+		default:
+			return fmt.Errorf("invalid body_sink_by_type mode %q for %q", mode, pattern)
+		}
+	}
+
+	if !validIDFormat(rl.TraceIDFormat) {
+		return fmt.Errorf("invalid trace_id_format %q (expected hex, uuid, ulid or ksuid)", rl.TraceIDFormat)
+	}
+
+	switch rl.OutputFormat {
+	case "", "default", outputFormatCaddy:
+	default:
+		return fmt.Errorf("invalid output_format %q", rl.OutputFormat)
+	}
+
+	// A layout without any reference element would log the same constant
+	// string for every request
+	switch rl.TimestampFormat {
+	case "", timestampRFC3339, timestampUnix, timestampUnixMilli:
+	default:
+		if time.Unix(0, 0).UTC().Format(rl.TimestampFormat) == rl.TimestampFormat {
+			return fmt.Errorf("invalid timestamp_format %q (expected rfc3339, unix, unix_milli or a Go time layout)", rl.TimestampFormat)
+		}
+	}
+
+	for _, pattern := range rl.SkipStatus {
+		if !validStatusPattern(pattern) {
+			return fmt.Errorf("invalid skip_status %q (expected a code such as 304 or a class such as 2xx)", pattern)
+		}
+	}
+	for pattern, name := range rl.LevelByStatus {
+		if !validStatusPattern(pattern) {
+			return fmt.Errorf("invalid level_by_status status %q (expected a code such as 404 or a class such as 5xx)", pattern)
+		}
+		if _, ok := parseLevel(name); !ok {
+			return fmt.Errorf("invalid level_by_status level %q for %s (expected debug, info, warn or error)", name, pattern)
+		}
+	}
+
+	for _, po := range rl.PathOverrides {
+		if po.Logger != nil {
+			if err := po.Logger.Validate(); err != nil {
+				return fmt.Errorf("path_override %q: %v", po.Path, err)
+			}
+		}
+	}
+	return nil
+}
+
+// cleanupTimeout bounds how long Cleanup waits for queued uploads and
+// webhook entries to be delivered
+const cleanupTimeout = 5 * time.Second
+
+// Cleanup stops background workers started in Provision, drains their
+// queues for at most cleanupTimeout, then closes the files they wrote to.
+// Workers that write log entries are stopped before the sinks they write
+// to are closed.
+func (rl *RequestLogger) Cleanup() error {
+	deadline := time.Now().Add(cleanupTimeout)
+
+	if rl.stopHeartbeat != nil {
+		close(rl.stopHeartbeat)
+		<-rl.heartbeatDone
+		rl.stopHeartbeat = nil
+	}
+	if rl.replaySize > 0 {
+		replays.Release(rl.replaySize)
+		rl.replaySize = 0
+	}
+	// Requests still in flight keep using these, so they are closed but
+	// never cleared; closed workers fall back to writing directly
+	if rl.coalescer != nil {
+		rl.coalescer.Close()
+	}
+	if rl.artifacts != nil {
+		rl.artifacts.Close(deadline)
+	}
+	if rl.webhook != nil {
+		rl.webhook.Close(deadline)
+	}
+	if rl.tenants != nil {
+		rl.tenants.Close()
+	}
+	if rl.formats != nil {
+		rl.formats.Close()
+	}
+	if rl.output != nil {
+		_ = rl.output.Close()
+	}
+	for _, po := range rl.PathOverrides {
+		if po.Logger != nil {
+			_ = po.Logger.Cleanup()
+		}
+	}
+	return nil
+}
+
+// waitUntil waits for done to be closed and reports false if deadline
+// passes first
+func waitUntil(done <-chan struct{}, deadline time.Time) bool {
+	timer := time.NewTimer(time.Until(deadline))
+	defer timer.Stop()
+	select {
+	case <-done:
+		return true
+	case <-timer.C:
+		return false
+	}
+}
+
+// collectBuildInfo gathers the build metadata logged with every entry.
+// Configured build_info values take precedence over values read from the
+// binary.
+func (rl *RequestLogger) collectBuildInfo() map[string]string {
+	info := make(map[string]string)
+
+	if rl.IncludeBuildInfo {
+		if bi, ok := debug.ReadBuildInfo(); ok {
+			info["go_version"] = bi.GoVersion
+			if bi.Main.Version != "" {
+				info["version"] = bi.Main.Version
+			}
+			for _, setting := range bi.Settings {
+				switch setting.Key {
+				case "vcs.revision", "vcs.time", "vcs.modified":
+					info[strings.TrimPrefix(setting.Key, "vcs.")] = setting.Value
+				}
+			}
+		}
+	}
+
+	for key, value := range rl.BuildInfo {
+		info[key] = value
+	}
+
+	if len(info) == 0 {
+		return nil
+	}
+	return info
+}
+
+// runHeartbeat periodically logs a heartbeat entry with the number of
+// requests seen since the previous heartbeat, until stop is closed
+func (rl *RequestLogger) runHeartbeat(interval time.Duration, stop <-chan struct{}, done chan<- struct{}) {
+	defer close(done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			rl.logger.Info("heartbeat",
+				zap.Int64("requests", atomic.SwapInt64(&rl.heartbeatRequests, 0)),
+				zap.Duration("interval", interval),
+			)
+		case <-stop:
+			return
+		}
+	}
+}
+
+// shouldSkipMethod checks if the request method should be skipped
+func (rl *RequestLogger) shouldSkipMethod(method string) bool {
+	for _, skipMethod := range rl.SkipMethods {
+		if strings.EqualFold(method, skipMethod) {
+			return true
+		}
+	}
+	return false
+}
+
+// skip counts a request that is not logged and optionally logs why
+func (rl *RequestLogger) skip(r *http.Request, reason string) {
+	countSkipped(reason)
+	if rl.LogSkipReason {
+		rl.logger.Debug(fmt.Sprintf("Skipped: %s %s", r.Method, r.URL.Path),
+			zap.String("method", r.Method),
+			zap.String("path", r.URL.Path),
+			zap.String("skip_reason", reason),
+		)
+	}
+}
+
+// shouldSkipStatus checks if the response status should be skipped
+func (rl *RequestLogger) shouldSkipStatus(status int) bool {
+	for _, pattern := range rl.SkipStatus {
+		if statusMatches(pattern, status) {
+			return true
+		}
+	}
+	return false
+}
+
+// shouldSkipPath checks if the request path should be skipped
+func (rl *RequestLogger) shouldSkipPath(path string) bool {
+	for _, skipPath := range rl.SkipPaths {
+		if strings.Contains(path, skipPath) {
+			return true
+		}
+	}
+	for _, re := range rl.skipPathRes {
+		if re.MatchString(path) {
+			return true
+		}
+	}
+	for _, pattern := range rl.SkipPathsGlob {
+		if matched, _ := pathpkg.Match(pattern, path); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// shouldSkipContentType checks if the request content type should be skipped
+func (rl *RequestLogger) shouldSkipContentType(contentType string) bool {
+	for _, skipType := range rl.SkipContentTypes {
+		if strings.Contains(strings.ToLower(contentType), strings.ToLower(skipType)) {
+			return true
+		}
+	}
+	return false
+}
+
+// collectHeaders returns the request headers selected for logging, or nil
+// if there are none
+func (rl *RequestLogger) collectHeaders(r *http.Request) any {
+	if rl.IncludeAllHeaders {
+		headers := make(map[string][]string)
+		for name, values := range r.Header {
+			if rl.isHeaderExcluded(name) {
+				continue
+			}
+			if rl.isHeaderRedacted(name) {
+				masked := make([]string, len(values))
+				for i := range masked {
+					masked[i] = redactedValue
+				}
+				values = masked
+			}
+			headers[name] = values
+		}
+		if len(headers) > 0 {
+			return rl.headerValue(headers)
+		}
+	} else if len(rl.IncludeHeaders) > 0 {
+		headers := make(map[string]string)
+		for _, headerName := range rl.IncludeHeaders {
+			if value := r.Header.Get(headerName); value != "" {
+				if rl.isHeaderRedacted(headerName) {
+					value = redactedValue
+				}
+				headers[headerName] = value
+			}
+		}
+		if len(headers) > 0 {
+			return rl.headerValue(headers)
+		}
+	}
+	return nil
+}
+
+// headerValue returns the collected headers as logged: the map itself, or
+// with stable_header_order a canonical JSON string with sorted names
+func (rl *RequestLogger) headerValue(headers any) any {
+	if !rl.StableHeaderOrder {
+		return headers
+	}
+	// encoding/json writes map keys in sorted order
+	encoded, err := json.Marshal(headers)
+	if err != nil {
+		return headers
+	}
+	return string(encoded)
+}
+
+// skipReason returns why the request should not be logged (method, path or
+// content_type), or an empty string if it should be logged. Requests with an
+// unexpected body are not skipped for their content type.
+func (rl *RequestLogger) skipReason(r *http.Request) string {
+	switch {
+	case rl.shouldSkipMethod(r.Method):
+		return "method"
+	case rl.SkipPreflight && isPreflight(r):
+		return "preflight"
+	case rl.shouldSkipPath(r.URL.Path):
+		return "path"
+	case rl.shouldSkipContentType(r.Header.Get("Content-Type")) && !(rl.LogUnexpectedBody && hasUnexpectedBody(r)):
+		return "content_type"
+	default:
+		return ""
+	}
+}
+
+// isPreflight reports whether r is a CORS preflight request: an OPTIONS
+// request carrying Access-Control-Request-Method
+func isPreflight(r *http.Request) bool {
+	return r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+}
+
+// hasUnexpectedBody reports whether a GET, HEAD or DELETE request declares
+// a body, through Content-Length or chunked transfer encoding
+func hasUnexpectedBody(r *http.Request) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodDelete:
+		return r.ContentLength > 0 || len(r.TransferEncoding) > 0
+	}
+	return false
+}
+
+// isHeaderExcluded checks if a header should be excluded from logging
+func (rl *RequestLogger) isHeaderExcluded(headerName string) bool {
+	for _, excludeHeader := range rl.ExcludeHeaders {
+		if strings.EqualFold(headerName, excludeHeader) {
+			return true
+		}
+	}
+	return false
+}
+
+// isHeaderRedacted checks if a header's values should be masked in logs
+func (rl *RequestLogger) isHeaderRedacted(headerName string) bool {
+	for _, redactHeader := range rl.RedactHeaders {
+		if strings.EqualFold(headerName, redactHeader) {
+			return true
+		}
+	}
+	return false
+}
+
+// ServeHTTP implements the middleware interface
+func (rl *RequestLogger) ServeHTTP(w http.ResponseWriter, r *http.Request, next caddyhttp.Handler) error {
+	if rl.HeartbeatInterval > 0 {
+		atomic.AddInt64(&rl.heartbeatRequests, 1)
+	}
+
+	// Hand requests matching a path override to its settings
+	if po := rl.pathOverride(r.URL.Path); po != nil {
+		if po.Skip {
+			rl.skip(r, "path")
+			return next.ServeHTTP(w, r)
+		}
+		return po.Logger.ServeHTTP(w, r, next)
+	}
+
+	// Propagate the request ID even if the request is not logged, so
+	// downstream logs can still be correlated
+	requestID := rl.requestID(w, r)
+
+	// Remember nonces of skipped requests too, so replaying a request that
+	// was not logged is still caught
+	var replayFields []zap.Field
+	if rl.ReplayDetection != nil {
+		replayFields = rl.ReplayDetection.Check(r, time.Now())
+	}
+
+	// Check if we should skip logging for this request
+	reason := rl.skipReason(r)
+	sampleRate := *rl.SampleRate
+	upstreamSampled, upstreamDecided := rl.upstreamSamplingDecision(r)
+	if reason == "" && upstreamDecided {
+		// Honor the decision taken upstream instead of sampling again
+		sampleRate = 1
+		if !upstreamSampled {
+			reason = "sample"
+		}
+	}
+	if reason == "" && !upstreamDecided && sampleRate < 1 && rand.Float64() >= sampleRate {
+		reason = "sample"
+	}
+	if reason == "" && !upstreamDecided && rl.sampler != nil {
+		keep, p := rl.sampler.Sample(time.Now())
+		sampleRate *= p
+		if !keep {
+			reason = "sample"
+		}
+	}
+	if rl.PropagateSamplingDecision {
+		decision := "0"
+		if reason == "" {
+			decision = "1"
+		}
+		r.Header.Set(rl.SamplingHeader, decision)
+		w.Header().Set(rl.SamplingHeader, decision)
+	}
+	// With keep_errors the sampling drop waits for the response
+	sampledOut := reason == "sample" && rl.KeepErrors
+	if sampledOut {
+		reason = ""
+	}
+	if reason != "" {
+		rl.skip(r, reason)
+		return next.ServeHTTP(w, r)
+	}
+
+	contentType := r.Header.Get("Content-Type")
+	start := time.Now()
+
+	// Resolve the tenant, which selects the log sink
+	var tenant string
+	if rl.TenantHeader != "" {
+		tenant = r.Header.Get(rl.TenantHeader)
+	}
+
+	// Read request body if needed
+	var requestBody []byte
+	var bodyTruncated, bodyTimedOut bool
+	var bodyReadTime time.Duration
+	var sampler *bodySampler
+	if rl.shouldCaptureBody() && r.Body != nil {
+		if rl.IncludeRequestBody && rl.BodySampleBytes > 0 && r.ContentLength > int64(rl.MaxBodySize) {
+			// Sample large bodies as the handler reads them instead of
+			// capturing only the head
+			sampler = newBodySampler(r.Body, rl.BodySampleBytes, r.ContentLength)
+			r.Body = sampler
+		} else {
+			requestBody, bodyTruncated, bodyTimedOut = captureBody(r, int64(rl.MaxBodySize), time.Duration(rl.BodyReadTimeout))
+			bodyReadTime = time.Since(start)
+		}
+	}
+
+	// Digest the whole body as the handler reads it
+	var bodyHasher *hashingReader
+	if rl.HashFullBody && r.Body != nil && r.Body != http.NoBody {
+		bodyHasher = newHashingReader(r.Body, rl.HashBody)
+		r.Body = bodyHasher
+	}
+
+	// Watch how the handler consumes the body
+	var stall *stallReader
+	if rl.LogBackendReadStall && r.Body != nil && r.Body != http.NoBody {
+		stall = &stallReader{ReadCloser: r.Body}
+		r.Body = stall
+	}
+
+	// Prepare log fields
+	headers := rl.collectHeaders(r)
+	var fields []zap.Field
+	if rl.OutputFormat == outputFormatCaddy {
+		fields = []zap.Field{
+			zap.Object("request", caddyRequest{
+				r:        r,
+				ip:       rl.loggedIP(remoteIP(r)),
+				clientIP: rl.loggedIP(rl.clientIP(r)),
+				uri:      redactURIQuery(r.RequestURI, rl.RedactQueryParams),
+				headers:  headers,
+			}),
+		}
+	} else {
+		fields = []zap.Field{
+			zap.String("method", r.Method),
+			zap.String("path", r.URL.Path),
+			zap.String("query", redactQuery(r.URL.RawQuery, rl.RedactQueryParams)),
+			zap.String("remote_addr", rl.loggedAddr(r.RemoteAddr)),
+			zap.String("user_agent", r.UserAgent()),
+			zap.String("referer", r.Referer()),
+			zap.String("host", r.Host),
+			zap.String("proto", r.Proto),
+			zap.String("content_type", contentType),
+			zap.Int64("content_length", r.ContentLength),
+			rl.timestampField(start),
+		}
+		if rl.TrustForwarded {
+			fields = append(fields, zap.String("client_ip", rl.loggedIP(rl.clientIP(r))))
+		}
+		if headers != nil {
+			fields = append(fields, zap.Any("headers", headers))
+		}
+	}
+
+	// Add the sampling probability so counts can be reweighted
+	if sampleRate < 1 || rl.sampler != nil {
+		fields = append(fields, zap.Float64("sample_rate", sampleRate))
+	}
+
+	if tenant != "" {
+		fields = append(fields, zap.String("tenant", tenant))
+	}
+
+	// Add generated trace ID
+	var traceID string
+	if rl.GenerateTraceID {
+		traceID = newID(rl.TraceIDFormat, start)
+		fields = append(fields, zap.String("trace_id", traceID))
+	}
+	if requestID != "" {
+		fields = append(fields, zap.String("request_id", requestID))
+	}
+
+	// Keep the request for replay
+	if rl.ReplayStoreSize > 0 {
+		truncated := sampler != nil || bodyTruncated || bodyTimedOut || r.ContentLength > int64(len(requestBody))
+		replays.Put(traceID, rl.replayEntryFor(r, requestBody, truncated))
+	}
+
+	// Decompress the logged copy only; the handler still gets the original
+	loggedBody := requestBody
+	if rl.DecodeBody && len(requestBody) > 0 {
+		encoding := r.Header.Get("Content-Encoding")
+		if decoded, truncated, ok := decodeContentEncoding(requestBody, encoding, rl.MaxBodySize); ok {
+			loggedBody = decoded
+			fields = append(fields, zap.String("request_body_encoding", encoding))
+			if truncated {
+				fields = append(fields, zap.Bool("request_body_decoded_truncated", true))
+			}
+		}
+	}
+
+	// Add request body if included and within the endpoint's budget. With
+	// body_for_statuses it is held back until the response status is known.
+	var bodyFields []zap.Field
+	logBody := rl.IncludeRequestBody || len(rl.BodyForStatuses) > 0
+	if logBody && len(loggedBody) > 0 {
+		if rl.bodyBudget == nil || rl.bodyBudget.Allow(r.Method+" "+requestRoute(r), len(loggedBody), start) {
+			bodyFields = append(bodyFields, rl.bodyFields(loggedBody, contentType)...)
+		} else {
+			bodyFields = append(bodyFields, zap.Bool("request_body_over_budget", true))
+		}
+	}
+	// Tell cut off bodies apart from complete ones; content_length shows
+	// how much was dropped when the client declared it
+	if logBody && bodyTruncated {
+		bodyFields = append(bodyFields, zap.Bool("request_body_truncated", true))
+	}
+	if len(rl.BodyForStatuses) == 0 {
+		fields = append(fields, bodyFields...)
+		bodyFields = nil
+	}
+	if rl.HashBody != "" && !rl.HashFullBody && len(requestBody) > 0 {
+		h := newBodyHash(rl.HashBody)
+		h.Write(requestBody)
+		fields = append(fields, zap.String("request_body_hash", hex.EncodeToString(h.Sum(nil))))
+		if bodyTruncated || bodyTimedOut {
+			fields = append(fields, zap.Bool("request_body_hash_partial", true))
+		}
+	}
+	fields = append(fields, rl.bodyContextFields(loggedBody, contentType)...)
+	if bodyTimedOut {
+		fields = append(fields, zap.Bool("body_read_timeout", true))
+	}
+	// Sampled bodies are read by the handler, so there is no read time
+	if rl.LogReadTiming && r.Body != nil && sampler == nil {
+		fields = append(fields, zap.Duration("body_read_time", bodyReadTime))
+	}
+
+	// Flag bodies on methods that should not carry one
+	if rl.LogUnexpectedBody && hasUnexpectedBody(r) {
+		fields = append(fields, zap.Bool("unexpected_body", true))
+	}
+
+	// Flag bodies whose actual length differs from the declared Content-Length
+	if rl.DetectLengthMismatch && !bodyTimedOut && rl.lengthMismatch(r.ContentLength, len(requestBody), bodyTruncated) {
+		fields = append(fields,
+			zap.Bool("length_mismatch", true),
+			zap.Int64("declared_length", r.ContentLength),
+			zap.Int("body_bytes_read", len(requestBody)),
+		)
+	}
+
+	fields = append(fields, rl.experimentFields(r)...)
+
+	if len(rl.RequestCost) > 0 {
+		size := max(r.ContentLength, int64(len(requestBody)))
+		fields = append(fields, zap.Float64("request_cost", requestCost(rl.RequestCost, r.Method, r.URL.Path, size)))
+	}
+
+	// Add client-reported timezone and preferred locale
+	if tz := rl.clientTimezone(r); tz != "" {
+		fields = append(fields, zap.String("client_tz", tz))
+	}
+	if rl.LogLocale {
+		if locale := primaryLocale(r.Header.Get("Accept-Language")); locale != "" {
+			fields = append(fields, zap.String("client_locale", locale))
+		}
+	}
+
+	// Add the proxy chain from the Forwarded header
+	if rl.ParseForwardedHeader {
+		if hops := parseForwarded(r.Header.Values("Forwarded")); len(hops) > 0 {
+			maskForwardedFor(hops, rl.loggedIP)
+			fields = append(fields, zap.Any("forwarded", hops))
+		}
+	}
+
+	// Add the cache key a caching layer would compute
+	if rl.LogCacheKey {
+		input, hash := cacheKey(r, rl.CacheKeyTemplate, rl.CacheKeyVary)
+		fields = append(fields, zap.String("cache_key", hash), zap.String("cache_key_input", input))
+	}
+
+	// Add build metadata
+	if len(rl.buildInfo) > 0 {
+		fields = append(fields, zap.Any("build", rl.buildInfo))
+	}
+
+	// Add time since server start
+	if rl.LogUptime {
+		fields = append(fields, zap.Duration("server_uptime", start.Sub(serverStart)))
+	}
+
+	// Add rolling request count for the client IP
+	if rl.clientCounter != nil {
+		count := rl.clientCounter.Increment(remoteIP(r), start)
+		fields = append(fields, zap.Int("client_request_count", count))
+	}
+
+	// Add the request's position on its connection
+	if rl.connCounter != nil {
+		fields = append(fields, zap.Int("conn_req_index", rl.connCounter.Increment(connectionKey(r), start)))
+	}
+
+	// Add QUIC details of HTTP/3 requests
+	if rl.LogHTTP3 && r.ProtoMajor == 3 {
+		fields = append(fields, http3Fields(r)...)
+	}
+
+	// Flag requests arriving during a spike above the baseline rate
+	if rl.rateBaseline != nil {
+		current, baseline := rl.rateBaseline.Observe(start)
+		if baseline > 0 && current > baseline*rl.RateAnomalyMultiplier {
+			fields = append(fields, zap.Bool("rate_anomaly", true))
+		}
+	}
+
+	if rl.IncludeTLS && r.TLS != nil {
+		fields = append(fields, tlsFields(r.TLS)...)
+	}
+
+	fields = append(fields, replayFields...)
+
+	// Add ALPN protocol and flag mismatches with the request's HTTP version
+	if rl.DetectProtocolAnomaly && r.TLS != nil && r.TLS.NegotiatedProtocol != "" {
+		fields = append(fields, zap.String("alpn", r.TLS.NegotiatedProtocol))
+		if protocolMismatch(r.TLS.NegotiatedProtocol, r.ProtoMajor) {
+			fields = append(fields, zap.Bool("protocol_mismatch", true))
+		}
+	}
+
+	// Add entropy of the captured body and query
+	if rl.LogEntropy {
+		if len(requestBody) > 0 {
+			fields = append(fields, zap.Float64("body_entropy", shannonEntropy(requestBody)))
+		}
+		if r.URL.RawQuery != "" {
+			fields = append(fields, zap.Float64("query_entropy", shannonEntropy([]byte(r.URL.RawQuery))))
+		}
+	}
+
+	// Add risk score from suspicious request signals
+	if rl.ComputeRiskScore {
+		score, signals := rl.riskScore(r, requestBody)
+		fields = append(fields, zap.Int("risk_score", score))
+		if len(signals) > 0 {
+			fields = append(fields, zap.Strings("risk_signals", signals))
+		}
+	}
+
+	level := rl.logLevel()
+
+	// Flag unusually large bodies, even when the body itself is not logged
+	if rl.LargeBodyThreshold > 0 {
+		size := r.ContentLength
+		if int64(len(requestBody)) > size {
+			size = int64(len(requestBody))
+		}
+		if size > int64(rl.LargeBodyThreshold) {
+			fields = append(fields, zap.Bool("large_body", true), zap.Int64("body_size", size))
+			level = raiseLevel(level, zapcore.WarnLevel)
+		}
+	}
+
+	// Add the score of the pluggable anomaly model
+	if rl.scorer != nil {
+		score := rl.scorer.Score(r)
+		fields = append(fields, zap.Float64("anomaly_score", score))
+		if rl.AnomalyThreshold > 0 && score > rl.AnomalyThreshold {
+			level = raiseLevel(level, zapcore.WarnLevel)
+		}
+	}
+
+	// Flag requests on the signature denylist
+	denylisted := false
+	if rl.denylist != nil {
+		signature := requestBodySignature(r.Method, r.URL.Path, requestBody)
+		fields = append(fields, zap.String("request_signature", signature))
+		if _, denylisted = rl.denylist[signature]; denylisted {
+			fields = append(fields, zap.Bool("denylisted", true))
+			level = raiseLevel(level, zapcore.WarnLevel)
+		}
+	}
+
+	// Log the request
+	message := fmt.Sprintf("Request: %s %s", r.Method, r.URL.Path)
+
+	if denylisted && rl.BlockDenylisted {
+		fields = append(fields, zap.Bool("blocked", true))
+		fields = append(fields, rl.contextFields(r)...)
+		rl.logRequest(r, tenant, level, message, fields, http.StatusForbidden, errorClassAuthFailure)
+		countLogged(r.Method, http.StatusForbidden)
+		return caddyhttp.Error(http.StatusForbidden, errors.New("request signature is denylisted"))
+	}
+
+	// Log before calling next unless some fields are only known afterwards
+	if !rl.logAfterResponse() {
+		fields = append(fields, rl.contextFields(r)...)
+		if class := rl.retentionClass(r.URL.Path, 0); class != "" {
+			fields = append(fields, zap.String("retention", class))
+		}
+		rl.logRequest(r, tenant, level, message, fields, 0, "")
+		countLogged(r.Method, 0)
+		return next.ServeHTTP(w, r)
+	}
+
+	var rec *responseRecorder
+	if rl.needsResponseRecorder() {
+		rec = newResponseRecorder(w)
+		if rl.IncludeResponseBody {
+			rec.captureBody(rl.MaxResponseBodySize)
+		}
+		w = rec
+	}
+
+	// Call next handler
+	err := next.ServeHTTP(w, r)
+
+	if sampler != nil {
+		if sample := sampler.Sample(); len(sample) > 0 {
+			bodyFields = append(bodyFields, rl.bodyFields(sample, contentType)...)
+			bodyFields = append(bodyFields, zap.Bool("request_body_sampled", true))
+		}
+		if len(rl.BodyForStatuses) == 0 {
+			fields = append(fields, bodyFields...)
+			bodyFields = nil
+		}
+	}
+
+	if bodyHasher != nil {
+		sum, complete := bodyHasher.Sum()
+		fields = append(fields, zap.String("request_body_hash", sum))
+		if !complete {
+			fields = append(fields, zap.Bool("request_body_hash_partial", true))
+		}
+	}
+
+	if stall != nil {
+		if gap := stall.MaxGap(); gap >= time.Duration(rl.BackendReadStallThreshold) {
+			fields = append(fields, zap.Bool("backend_read_stall", true), zap.Duration("backend_read_max_gap", gap))
+		}
+	}
+
+	if sampledOut && err == nil && (rec == nil || responseStatus(rec, err) < 400) {
+		rl.skip(r, "sample")
+		return err
+	}
+	if sampledOut {
+		fields = append(fields, zap.Bool("sample_kept_error", true))
+	}
+
+	elapsed := time.Since(start)
+	if elapsed < time.Duration(rl.MinDuration) {
+		rl.skip(r, "min_duration")
+		return err
+	}
+	if rec != nil && rl.shouldSkipStatus(responseStatus(rec, err)) {
+		rl.skip(r, "status")
+		return err
+	}
+
+	fields = append(fields, rl.responseFields(r, rec, err, elapsed)...)
+	if rl.LogDeadlineMargin {
+		if deadline, budget, ok := requestDeadline(r, start); ok {
+			fields = append(fields, deadlineFields(deadline, budget, start.Add(elapsed))...)
+		}
+	}
+	var status int
+	var errClass string
+	if rec != nil {
+		status = responseStatus(rec, err)
+		deadline, _, ok := requestDeadline(r, start)
+		errClass = classifyError(status, err, ok && start.Add(elapsed).After(deadline))
+	}
+	if rl.ClassifyErrors && errClass != "" {
+		fields = append(fields, zap.String("error_class", errClass))
+	}
+	fields = append(fields, rl.contextFields(r)...)
+	if rec != nil {
+		level = rl.responseLevel(level, rec, err)
+	}
+	if slices.Contains(rl.BodyForStatuses, status) {
+		fields = append(fields, bodyFields...)
+	}
+	if class := rl.retentionClass(r.URL.Path, status); class != "" {
+		fields = append(fields, zap.String("retention", class))
+	}
+	rl.logRequest(r, tenant, level, message, fields, status, errClass)
+	countLogged(r.Method, status)
+
+	// Emit the APM transaction as an entry of its own
+	if rl.APMFormat {
+		rl.loggerFor(tenant).Info("transaction", transactionFields(r, traceID, status, err, elapsed)...)
+	}
+
+	return err
+}
+
+// Body handling modes for body_sink_by_type
+const (
+	bodyModeRaw    = "raw"
+	bodyModeBase64 = "base64"
+	bodyModeHash   = "hash"
+	bodyModeSkip   = "skip"
+)
+
+// bodyMode returns how the body of the given content type is logged. The
+// longest matching body_sink_by_type pattern wins; unmatched types are
+// classified by auto_body_encoding if enabled, and otherwise fall back to the
+// global base64_encode_body setting.
+func (rl *RequestLogger) bodyMode(contentType string, body []byte) string {
+	contentType = strings.ToLower(contentType)
+	mode, matched := "", ""
+	for pattern, m := range rl.BodySinkByType {
+		if strings.Contains(contentType, strings.ToLower(pattern)) && len(pattern) > len(matched) {
+			mode, matched = m, pattern
+		}
+	}
+	if mode != "" {
+		return mode
+	}
+	if rl.AutoBodyEncoding {
+		if isTextBody(body) {
+			return bodyModeRaw
+		}
+		return bodyModeBase64
+	}
+	if rl.Base64EncodeBody {
+		return bodyModeBase64
+	}
+	return bodyModeRaw
+}
+
+// bodyFields returns the fields describing a captured request body
+func (rl *RequestLogger) bodyFields(body []byte, contentType string) []zap.Field {
+	// Extract the configured values in place of the whole JSON body
+	if len(rl.LogJSONFields) > 0 && isJSONContentType(contentType) {
+		doc, err := rl.protectedJSONBody(body)
+		if err != nil {
+			return []zap.Field{zap.String("json_parse_error", err.Error())}
+		}
+		return []zap.Field{zap.Any("json_fields", extractJSONFields(doc, rl.LogJSONFields))}
+	}
+
+	// Only log allowlisted JSON fields; anything else is omitted entirely
+	if len(rl.BodyAllowedFields) > 0 {
+		filtered, ok := []byte(nil), false
+		if isJSONContentType(contentType) {
+			filtered, ok = allowJSONFields(body, rl.BodyAllowedFields)
+		}
+		if !ok {
+			return []zap.Field{zap.Bool("request_body_omitted", true)}
+		}
+		body = filtered
+	}
+
+	// Reshape JSON bodies with the jq filter, logging them unchanged if it fails
+	if rl.jqFilter != nil && isJSONContentType(contentType) {
+		if transformed, ok := runJQ(rl.jqFilter, body); ok {
+			body = transformed
+		}
+	}
+
+	// Keep whole lines of text bodies; max_body_size still caps the capture
+	if rl.MaxBodyLines > 0 && isTextContentType(contentType) {
+		body = truncateLines(body, rl.MaxBodyLines)
+	}
+
+	mode := rl.bodyMode(contentType, body)
+	switch mode {
+	case bodyModeSkip:
+		return nil
+	case bodyModeHash:
+		// hash_body logs the digest itself
+		if rl.HashBody != "" {
+			return nil
+		}
+		sum := sha256.Sum256(body)
+		return []zap.Field{zap.String("request_body_hash", hex.EncodeToString(sum[:]))}
+	}
+
+	// Keep the log lean and store the full body out of band. The stored
+	// copy gets the same PII controls as a logged body.
+	if rl.artifacts != nil {
+		if ref := rl.artifacts.Enqueue(rl.protectBody(body)); ref != "" {
+			return []zap.Field{zap.String("body_artifact", ref)}
+		}
+		return nil
+	}
+
+	var decoded []byte
+	isBase64 := false
+	if rl.DetectBase64 {
+		decoded, isBase64 = decodeBase64Body(body)
+	}
+
+	var fields []zap.Field
+	if rl.AutoBodyEncoding {
+		fields = append(fields, zap.String("body_encoding", mode))
+	}
+	switch {
+	case isBase64:
+		// Body is already base64, so log it as is instead of encoding it again
+		fields = append(fields, zap.Bool("body_is_base64", true))
+		if mode == bodyModeBase64 {
+			fields = append(fields, zap.ByteString("request_body_b64", body))
+		} else {
+			fields = append(fields, zap.ByteString("request_body", body))
+		}
+		if rl.DecodeBase64Body {
+			fields = append(fields, zap.ByteString("request_body_decoded", decoded))
+		}
+	case mode == bodyModeBase64:
+		encoded := base64.StdEncoding.EncodeToString(body)
+		fields = append(fields, zap.String("request_body_b64", encoded))
+	default:
+		fields = append(fields, zap.ByteString("request_body", body))
+	}
+	return fields
+}
+
+// bodyContextFields returns the body_context_fields found in a JSON body.
+// Missing paths and bodies that do not parse, e.g. because they were cut
+// off at max_body_size, are left out.
+func (rl *RequestLogger) bodyContextFields(body []byte, contentType string) []zap.Field {
+	if len(rl.BodyContextFields) == 0 || len(body) == 0 || !isJSONContentType(contentType) {
+		return nil
+	}
+	doc, err := rl.protectedJSONBody(body)
+	if err != nil {
+		return nil
+	}
+
+	names := make([]string, 0, len(rl.BodyContextFields))
+	for name := range rl.BodyContextFields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var fields []zap.Field
+	for _, name := range names {
+		if value, ok := lookupJSONPath(doc, rl.BodyContextFields[name]); ok {
+			fields = append(fields, zap.Any(name, value))
+		}
+	}
+	return fields
+}
+
+// protectedJSONBody parses a JSON body after applying the protections the
+// logged body gets, in the same order: body_allowed_fields, scrub_body_pii,
+// body: redaction strategies, the redactor and tokenize_pii. Values taken
+// from it therefore never reveal more than the logged body would. An error
+// is returned if the body, or the body after redaction, does not parse.
+func (rl *RequestLogger) protectedJSONBody(body []byte) (any, error) {
+	if _, err := parseJSON(body); err != nil {
+		return nil, err
+	}
+	if len(rl.BodyAllowedFields) > 0 {
+		body, _ = allowJSONFields(body, rl.BodyAllowedFields)
+	}
+	return parseJSON(rl.protectBody(body))
+}
+
+// protectBody applies scrub_body_pii, the body redaction strategies, the
+// redactor and tokenize_pii to a request body, as logRequest does for the
+// logged body
+func (rl *RequestLogger) protectBody(body []byte) []byte {
+	if slices.Contains(rl.DeidentifyPipeline, "scrub_body_pii") {
+		body = []byte(scrubPII(string(body)))
+	}
+	if rl.strategies != nil {
+		body = rl.strategies.redactBody(body)
+	}
+	if rl.redactor != nil {
+		body = rl.redactor.Redact("request_body", body)
+	}
+	if rl.tokenizer != nil {
+		body = []byte(rl.tokenizer.Tokenize(string(body)))
+	}
+	return body
+}
+
+// lengthMismatch reports whether the number of body bytes read disagrees
+// with the declared Content-Length. Truncated reads only prove the body
+// was longer than what was read, and unknown lengths are never a mismatch.
+func (rl *RequestLogger) lengthMismatch(declared int64, read int, truncated bool) bool {
+	if declared < 0 {
+		return false
+	}
+	if truncated {
+		return declared <= int64(read)
+	}
+	return declared != int64(read)
+}
+
+// shouldCaptureBody reports whether the request body has to be read,
+// either to log it or to derive other fields from it
+func (rl *RequestLogger) shouldCaptureBody() bool {
+	return rl.IncludeRequestBody || len(rl.BodyForStatuses) > 0 || rl.ReplayStoreSize > 0 || rl.LogEntropy || rl.ComputeRiskScore || rl.DetectLengthMismatch || rl.LogReadTiming ||
+		len(rl.SignatureDenylist) > 0 || len(rl.BodyContextFields) > 0 || (rl.HashBody != "" && !rl.HashFullBody)
+}
+
+// logAfterResponse reports whether the log entry has to wait for the
+// downstream handler to finish
+func (rl *RequestLogger) logAfterResponse() bool {
+	return rl.LogTiming || rl.MinDuration > 0 || rl.LogUpstream || rl.LogBackendReadStall || rl.BodySampleBytes > 0 || rl.LogDeadlineMargin || rl.HashFullBody ||
+		rl.needsResponseRecorder()
+}
+
+// needsResponseRecorder reports whether the response has to be observed
+func (rl *RequestLogger) needsResponseRecorder() bool {
+	return rl.IncludeResponse || rl.LogStatusClass || rl.OutputFormat == outputFormatCaddy || rl.LogRedirects ||
+		rl.LogStreamingStats || rl.APMFormat || rl.LogContentNegotiation || rl.IncludeResponseBody ||
+		len(rl.LevelByStatus) > 0 || len(rl.SkipStatus) > 0 || len(rl.BodyForStatuses) > 0 || rl.KeepErrors || rl.CoalesceWindow > 0 || rl.ClassifyErrors || rl.hasStatusRetentionRules()
+}
+
+// responseFields returns the fields that are only available after the
+// downstream handler has run. rec is nil unless the response was recorded.
+func (rl *RequestLogger) responseFields(r *http.Request, rec *responseRecorder, err error, elapsed time.Duration) []zap.Field {
+	var fields []zap.Field
+
+	// The caddy layout reports duration in seconds itself
+	if rl.LogTiming && rl.OutputFormat != outputFormatCaddy {
+		fields = append(fields, zap.Duration("duration", elapsed))
+	}
+
+	if rec != nil && rl.OutputFormat == outputFormatCaddy {
+		fields = append(fields,
+			zap.Float64("duration", elapsed.Seconds()),
+			zap.Int64("size", rec.size),
+			zap.Int("status", responseStatus(rec, err)),
+		)
+		if rl.IncludeAllHeaders {
+			fields = append(fields, zap.Any("resp_headers", rec.Header()))
+		}
+	}
+
+	if rec != nil && rl.IncludeResponse {
+		// The caddy layout already has its own status field
+		if rl.OutputFormat != outputFormatCaddy {
+			fields = append(fields, zap.Int("status", responseStatus(rec, err)))
+		}
+		fields = append(fields, zap.Int64("response_size", rec.size))
+	}
+
+	if rec != nil && rl.IncludeResponseBody {
+		fields = append(fields, rl.responseBodyFields(rec)...)
+	}
+
+	if rec != nil && rl.LogStatusClass {
+		fields = append(fields, zap.String("status_class", fmt.Sprintf("%dxx", responseStatus(rec, err)/100)))
+	}
+
+	if rec != nil && rl.LogRedirects {
+		if status := responseStatus(rec, err); status >= 300 && status < 400 {
+			location := rec.Header().Get("Location")
+			fields = append(fields,
+				zap.Bool("redirect", true),
+				zap.Int("redirect_status", status),
+				zap.String("redirect_location", location),
+			)
+			if redirectsToItself(r, location) {
+				fields = append(fields, zap.Bool("redirect_loop", true))
+			}
+		}
+	}
+
+	// Streams only end when the handler returns, so report what was streamed
+	if rec != nil && rl.LogStreamingStats && rec.wroteHeader && rec.streaming() {
+		fields = append(fields,
+			zap.Bool("streaming", true),
+			zap.Int64("stream_bytes", rec.size),
+			zap.Duration("stream_duration", time.Since(rec.headerTime)),
+		)
+	}
+
+	// Compare what the client asked for with what was served
+	if rec != nil && rl.LogContentNegotiation {
+		if contentType := rec.Header().Get("Content-Type"); contentType != "" {
+			mediaType, _, err := mime.ParseMediaType(contentType)
+			if err != nil {
+				mediaType = contentType
+			}
+			fields = append(fields, zap.String("negotiated_representation", mediaType))
+			if !acceptsMediaType(r.Header.Get("Accept"), mediaType) {
+				fields = append(fields, zap.Bool("not_acceptable", true))
+			}
+		}
+	}
+
+	if rl.LogUpstream {
+		if upstream := replacerValue(r, "http.reverse_proxy.upstream.hostport"); upstream != "" {
+			fields = append(fields, zap.String("upstream", upstream))
+		}
+	}
+
+	return fields
+}
+
+// responseBodyFields returns the fields describing the captured response
+// body, leaving out bodies of skipped content types
+func (rl *RequestLogger) responseBodyFields(rec *responseRecorder) []zap.Field {
+	body := rec.capturedBody()
+	if len(body) == 0 || rl.shouldSkipContentType(rec.Header().Get("Content-Type")) {
+		return nil
+	}
+
+	var fields []zap.Field
+	if rl.Base64EncodeBody {
+		fields = append(fields, zap.String("response_body_b64", base64.StdEncoding.EncodeToString(body)))
+	} else {
+		fields = append(fields, zap.ByteString("response_body", body))
+	}
+	if rec.size > int64(len(body)) {
+		fields = append(fields, zap.Bool("response_body_truncated", true))
+	}
+	return fields
+}
+
+// upstreamSamplingDecision returns the sampling decision carried by the
+// request's sampling header, if propagate_sampling_decision is set and the
+// header holds a boolean such as 1 or 0
+func (rl *RequestLogger) upstreamSamplingDecision(r *http.Request) (sampled, ok bool) {
+	if !rl.PropagateSamplingDecision {
+		return false, false
+	}
+	value := r.Header.Get(rl.SamplingHeader)
+	if value == "" {
+		return false, false
+	}
+	sampled, err := strconv.ParseBool(value)
+	return sampled, err == nil
+}
+
+// requestID returns the request's ID from the request ID header. If there
+// is none and generate_request_id is set, a UUID is generated and set on
+// both the request and the response.
+func (rl *RequestLogger) requestID(w http.ResponseWriter, r *http.Request) string {
+	if rl.RequestIDHeader == "" {
+		return ""
+	}
+	if id := r.Header.Get(rl.RequestIDHeader); id != "" {
+		return id
+	}
+	if !rl.GenerateRequestID {
+		return ""
+	}
+	id := newUUID()
+	r.Header.Set(rl.RequestIDHeader, id)
+	w.Header().Set(rl.RequestIDHeader, id)
+	return id
+}
+
+// clientTimezone returns the timezone reported by the client through the
+// configured header or cookie
+func (rl *RequestLogger) clientTimezone(r *http.Request) string {
+	if rl.TimezoneHeader != "" {
+		if tz := r.Header.Get(rl.TimezoneHeader); tz != "" {
+			return tz
+		}
+	}
+	if rl.TimezoneCookie != "" {
+		if cookie, err := r.Cookie(rl.TimezoneCookie); err == nil {
+			return cookie.Value
+		}
+	}
+	return ""
+}
+
+// experimentFields returns the A/B test variant of the request from the
+// experiment header or cookie. Without one, a variant is picked from
+// experiment_variants by hashing the client IP, so a client always lands
+// in the same bucket; such variants are flagged experiment_bucketed.
+func (rl *RequestLogger) experimentFields(r *http.Request) []zap.Field {
+	if rl.ExperimentHeader != "" {
+		if variant := r.Header.Get(rl.ExperimentHeader); variant != "" {
+			return []zap.Field{zap.String("experiment_variant", variant)}
+		}
+	}
+	if rl.ExperimentCookie != "" {
+		if cookie, err := r.Cookie(rl.ExperimentCookie); err == nil && cookie.Value != "" {
+			return []zap.Field{zap.String("experiment_variant", cookie.Value)}
+		}
+	}
+	if len(rl.ExperimentVariants) == 0 {
+		return nil
+	}
+	h := fnv.New32a()
+	h.Write([]byte(rl.clientIP(r)))
+	variant := rl.ExperimentVariants[h.Sum32()%uint32(len(rl.ExperimentVariants))]
+	return []zap.Field{zap.String("experiment_variant", variant), zap.Bool("experiment_bucketed", true)}
+}
+
+// primaryLocale returns the language tag with the highest quality value in
+// an Accept-Language header, preferring earlier tags on ties
+func primaryLocale(acceptLanguage string) string {
+	best, bestQ := "", 0.0
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		tag = strings.TrimSpace(tag)
+		if tag == "" || tag == "*" {
+			continue
+		}
+
+		q := 1.0
+		if qStr, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(qStr, 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+
+		if q > bestQ {
+			best, bestQ = tag, q
+		}
+	}
+	return best
+}
+
+// connIdleTimeout is how long a connection may go without requests before
+// its request index restarts, matching Caddy's default idle timeout
+const connIdleTimeout = 5 * time.Minute
+
+// connectionKey identifies the connection a request arrived on by its local
+// and remote addresses, falling back to the request's remote address when
+// the connection is not in the request context (e.g. HTTP/3)
+func connectionKey(r *http.Request) string {
+	if conn, ok := r.Context().Value(caddyhttp.ConnCtxKey).(net.Conn); ok {
+		return conn.LocalAddr().String() + "|" + conn.RemoteAddr().String()
+	}
+	return r.RemoteAddr
+}
+
+// protocolMismatch reports whether the ALPN-negotiated protocol disagrees
+// with the HTTP major version the request actually arrived with
+func protocolMismatch(alpn string, protoMajor int) bool {
+	switch alpn {
+	case "h2":
+		return protoMajor != 2
+	case "h3":
+		return protoMajor != 3
+	case "http/1.1", "http/1.0":
+		return protoMajor != 1
+	default:
+		return false
+	}
+}
+
+// remoteIP returns the IP part of the request's remote address
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// contextFields returns the fields read from values other handlers in the
+// chain have set on the request, such as authentication results, and the
+// fields built from request placeholders
+func (rl *RequestLogger) contextFields(r *http.Request) []zap.Field {
+	var fields []zap.Field
+
+	if rl.AuthResultVar != "" {
+		user := replacePlaceholders(r, rl.AuthResultVar)
+		if user != "" {
+			fields = append(fields, zap.String("auth_user", user))
+		}
+		fields = append(fields, zap.Bool("authenticated", user != ""))
+	}
+
+	if rl.RateLimitZoneVar != "" {
+		if zone := caddyhttp.GetVar(r.Context(), rl.RateLimitZoneVar); zone != nil {
+			if s := fmt.Sprint(zone); s != "" {
+				fields = append(fields, zap.String("rate_zone", s))
+			}
+		}
+	}
+
+	if rl.DownstreamServiceVar != "" {
+		if service := caddyhttp.GetVar(r.Context(), rl.DownstreamServiceVar); service != nil {
+			if s := fmt.Sprint(service); s != "" {
+				fields = append(fields, zap.String("downstream_service", s))
+			}
+		}
+	}
+
+	if rl.FeatureFlagVar != "" {
+		switch flags := caddyhttp.GetVar(r.Context(), rl.FeatureFlagVar).(type) {
+		case nil:
+		case string:
+			if flags != "" {
+				fields = append(fields, zap.String("feature_flags", flags))
+			}
+		default:
+			fields = append(fields, zap.Any("feature_flags", flags))
+		}
+	}
+
+	if len(rl.ContextKeys) > 0 {
+		values := make(map[string]any)
+		for _, key := range rl.ContextKeys {
+			if value := caddyhttp.GetVar(r.Context(), key); value != nil {
+				values[key] = value
+			}
+		}
+		if len(values) > 0 {
+			fields = append(fields, zap.Any("context", values))
+		}
+	}
+
+	fields = append(fields, rl.placeholderFields(r)...)
+
+	return fields
+}
+
+// staticLoggerName returns the name given to loggers created at provision
+// time. Names with placeholders can only be resolved per request.
+func (rl *RequestLogger) staticLoggerName() string {
+	if strings.Contains(rl.LoggerName, "{") {
+		return "request_logger"
+	}
+	return rl.LoggerName
+}
+
+// placeholderFields returns logger_name and extra_fields with their
+// placeholders expanded for r
+func (rl *RequestLogger) placeholderFields(r *http.Request) []zap.Field {
+	var fields []zap.Field
+	if strings.Contains(rl.LoggerName, "{") {
+		fields = append(fields, zap.String("logger_name", replacePlaceholders(r, rl.LoggerName)))
+	}
+
+	names := make([]string, 0, len(rl.ExtraFields))
+	for name := range rl.ExtraFields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fields = append(fields, zap.String(name, replacePlaceholders(r, rl.ExtraFields[name])))
+	}
+	return fields
+}
+
+// replacePlaceholders expands the placeholders in s with the request's
+// replacer; unknown placeholders are replaced with an empty string
+func replacePlaceholders(r *http.Request, s string) string {
+	repl, ok := r.Context().Value(caddy.ReplacerCtxKey).(*caddy.Replacer)
+	if !ok {
+		return ""
+	}
+	return repl.ReplaceAll(s, "")
+}
+
+// responseLevel adjusts the log level based on the recorded response
+func (rl *RequestLogger) responseLevel(level zapcore.Level, rec *responseRecorder, err error) zapcore.Level {
+	// The status level replaces log_level, but never lowers a level that
+	// another check already raised
+	if statusLevel, ok := rl.statusLevel(responseStatus(rec, err)); ok {
+		if level == rl.logLevel() {
+			level = statusLevel
+		} else {
+			level = raiseLevel(level, statusLevel)
+		}
+	}
+	if rl.LogRedirects {
+		if status := responseStatus(rec, err); status >= 300 && status < 400 {
+			level = raiseLevel(level, zapcore.InfoLevel)
+		}
+	}
+	return level
+}
+
+// redirectsToItself reports whether location points back at the request URL
+func redirectsToItself(r *http.Request, location string) bool {
+	if location == "" {
+		return false
+	}
+	target, err := r.URL.Parse(location)
+	if err != nil {
+		return false
+	}
+	if target.Host != "" && !strings.EqualFold(target.Host, r.Host) {
+		return false
+	}
+	return target.Path == r.URL.Path && target.RawQuery == r.URL.RawQuery
+}
+
+// replacerValue returns the value of a placeholder variable from the
+// request's replacer, or an empty string if it is not set
+func replacerValue(r *http.Request, variable string) string {
+	repl, ok := r.Context().Value(caddy.ReplacerCtxKey).(*caddy.Replacer)
+	if !ok {
+		return ""
+	}
+	value, _ := repl.GetString(variable)
+	return value
+}
+
+// teeSinks adds the multi_format and webhook sinks to logger, so entries
+// routed to a tenant sink still reach them
+func (rl *RequestLogger) teeSinks(logger *zap.Logger) *zap.Logger {
+	if rl.formats != nil {
+		logger = rl.formats.Tee(logger)
+	}
+	if rl.webhook != nil {
+		logger = rl.webhook.Tee(logger)
+	}
+	return logger
+}
+
+// loggerFor returns the logger for entries of the given tenant
+func (rl *RequestLogger) loggerFor(tenant string) *zap.Logger {
+	if rl.tenants != nil {
+		return rl.tenants.Logger(tenant, rl.logger)
+	}
+	return rl.logger
+}
+
+// logRequest applies the configured field rewrites and writes the log
+// entry at the given level, attaching it to the active trace span if
+// configured. status and errClass describe the response, if known, and
+// keep entries with different outcomes from being coalesced.
+func (rl *RequestLogger) logRequest(r *http.Request, tenant string, level zapcore.Level, message string, fields []zap.Field, status int, errClass string) {
+	deidentify(rl.DeidentifyPipeline, fields)
+	if rl.strategies != nil {
+		rl.strategies.Apply(fields)
+	}
+
+	if len(rl.FieldTypes) > 0 {
+		coerceFields(fields, rl.FieldTypes, func(field, typ string) {
+			rl.logger.Warn("cannot convert log field", zap.String("field", field), zap.String("type", typ))
+		})
+	}
+
+	if rl.redactor != nil {
+		rewriteBodyFields(fields, rl.SensitiveFields, func(key, value string) string {
+			return string(rl.redactor.Redact(key, []byte(value)))
+		})
+	}
+	if rl.tokenizer != nil {
+		rewriteBodyFields(fields, rl.SensitiveFields, func(_, value string) string {
+			return rl.tokenizer.Tokenize(value)
+		})
+	}
+
+	if rl.AttachToSpan && attachToSpan(r, message, fields) && rl.SpanOnly {
+		return
+	}
+
+	logger := rl.loggerFor(tenant)
+	if rl.coalescer != nil {
+		rl.coalescer.Add(logger, requestSignature(r, status, errClass)+"\x00"+tenant, level, message, fields)
+		return
+	}
+
+	if ce := logger.Check(level, message); ce != nil {
+		ce.Write(fields...)
+	}
+}
+
+// Keywords for timestamp_format
+const (
+	timestampRFC3339   = "rfc3339"
+	timestampUnix      = "unix"
+	timestampUnixMilli = "unix_milli"
+)
+
+// timestampField returns the timestamp field in the configured format
+func (rl *RequestLogger) timestampField(t time.Time) zap.Field {
+	switch rl.TimestampFormat {
+	case "":
+		return zap.Time("timestamp", t)
+	case timestampRFC3339:
+		return zap.String("timestamp", t.Format(time.RFC3339Nano))
+	case timestampUnix:
+		return zap.Int64("timestamp", t.Unix())
+	case timestampUnixMilli:
+		return zap.Int64("timestamp", t.UnixMilli())
+	default:
+		return zap.String("timestamp", t.Format(rl.TimestampFormat))
+	}
+}
+
+// logLevel returns the configured log level; unknown levels log at info
+func (rl *RequestLogger) logLevel() zapcore.Level {
+	if level, ok := parseLevel(rl.LogLevel); ok {
+		return level
+	}
+	return zapcore.InfoLevel
+}
+
+// parseLevel returns the level named debug, info, warn or error
+func parseLevel(name string) (zapcore.Level, bool) {
+	switch name {
+	case "debug":
+		return zapcore.DebugLevel, true
+	case "info":
+		return zapcore.InfoLevel, true
+	case "warn":
+		return zapcore.WarnLevel, true
+	case "error":
+		return zapcore.ErrorLevel, true
+	default:
+		return zapcore.InfoLevel, false
+	}
+}
+
+// statusLevel returns the level_by_status level for a status code. An
+// exact code takes precedence over its class.
+func (rl *RequestLogger) statusLevel(status int) (zapcore.Level, bool) {
+	var match string
+	for pattern, name := range rl.LevelByStatus {
+		if statusMatches(pattern, status) && (match == "" || !strings.EqualFold(pattern[1:], "xx")) {
+			match = name
+		}
+	}
+	if match == "" {
+		return zapcore.InfoLevel, false
+	}
+	return parseLevel(match)
+}
+
+// raiseLevel returns the more severe of level and min
+func raiseLevel(level, min zapcore.Level) zapcore.Level {
+	if level < min {
+		return min
+	}
+	return level
+}
+
+// UnmarshalCaddyfile implements caddyfile.Unmarshaler.
 func (rl *RequestLogger) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
 	for d.Next() {
 		for d.NextBlock(0) {
@@ -302,12 +2530,143 @@ func (rl *RequestLogger) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
 				if !d.Args(&rl.LogLevel) {
 					return d.ArgErr()
 				}
+			case "timestamp_format":
+				if !d.Args(&rl.TimestampFormat) {
+					return d.ArgErr()
+				}
+			case "level_by_status":
+				if rl.LevelByStatus == nil {
+					rl.LevelByStatus = make(map[string]string)
+				}
+				for nesting := d.Nesting(); d.NextBlock(nesting); {
+					pattern := d.Val()
+					var level string
+					if !d.Args(&level) {
+						return d.ArgErr()
+					}
+					rl.LevelByStatus[pattern] = level
+				}
+			case "output_format":
+				if !d.Args(&rl.OutputFormat) {
+					return d.ArgErr()
+				}
 			case "include_request_body":
 				rl.IncludeRequestBody = true
+			case "body_for_statuses":
+				args := d.RemainingArgs()
+				if len(args) == 0 {
+					return d.ArgErr()
+				}
+				for _, arg := range args {
+					code, err := strconv.Atoi(arg)
+					if err != nil {
+						return d.Errf("invalid status code %q", arg)
+					}
+					rl.BodyForStatuses = append(rl.BodyForStatuses, code)
+				}
+			case "log_timing":
+				rl.LogTiming = true
+			case "min_duration":
+				var err error
+				if rl.MinDuration, err = parseDurationArg(d); err != nil {
+					return err
+				}
+			case "include_response":
+				rl.IncludeResponse = true
+			case "log_status_class":
+				rl.LogStatusClass = true
 			case "include_all_headers":
 				rl.IncludeAllHeaders = true
 			case "base64_encode_body":
 				rl.Base64EncodeBody = true
+			case "auto_body_encoding":
+				rl.AutoBodyEncoding = true
+			case "body_allowed_fields":
+				rl.BodyAllowedFields = append(rl.BodyAllowedFields, d.RemainingArgs()...)
+			case "body_log_budget":
+				var sizeStr string
+				if !d.Args(&sizeStr) {
+					return d.ArgErr()
+				}
+				var err error
+				rl.BodyLogBudgetBytesPerSec, err = parseSize(sizeStr)
+				if err != nil {
+					return d.Errf("invalid size: %v", err)
+				}
+			case "body_context_fields":
+				if rl.BodyContextFields == nil {
+					rl.BodyContextFields = make(map[string]string)
+				}
+				for nesting := d.Nesting(); d.NextBlock(nesting); {
+					name := d.Val()
+					var path string
+					if !d.Args(&path) {
+						return d.ArgErr()
+					}
+					rl.BodyContextFields[name] = path
+				}
+			case "log_json_fields":
+				rl.LogJSONFields = append(rl.LogJSONFields, d.RemainingArgs()...)
+			case "body_jq_filter":
+				if !d.Args(&rl.BodyJQFilter) {
+					return d.ArgErr()
+				}
+			case "body_sink_by_type":
+				var pattern, mode string
+				if !d.Args(&pattern, &mode) {
+					return d.ArgErr()
+				}
+				if rl.BodySinkByType == nil {
+					rl.BodySinkByType = make(map[string]string)
+				}
+				rl.BodySinkByType[pattern] = mode
+			case "hash_body":
+				switch args := d.RemainingArgs(); len(args) {
+				case 0:
+					rl.HashBody = hashSHA256
+				case 1:
+					rl.HashBody = args[0]
+				default:
+					return d.ArgErr()
+				}
+			case "hash_full_body":
+				rl.HashFullBody = true
+			case "artifact_store":
+				if !d.Args(&rl.ArtifactStore) {
+					return d.ArgErr()
+				}
+			case "tokenize_pii":
+				rl.TokenizePII = true
+			case "pii_key":
+				if !d.Args(&rl.PIIKey) {
+					return d.ArgErr()
+				}
+			case "deidentify_pipeline":
+				rl.DeidentifyPipeline = append(rl.DeidentifyPipeline, d.RemainingArgs()...)
+			case "sensitive_fields":
+				rl.SensitiveFields = append(rl.SensitiveFields, d.RemainingArgs()...)
+			case "redactor":
+				if !d.Args(&rl.RedactorName) {
+					return d.ArgErr()
+				}
+			case "detect_base64":
+				rl.DetectBase64 = true
+			case "decode_base64_body":
+				rl.DecodeBase64Body = true
+			case "decode_body":
+				rl.DecodeBody = true
+			case "include_response_body":
+				rl.IncludeResponseBody = true
+			case "max_response_body_size":
+				var sizeStr string
+				if !d.Args(&sizeStr) {
+					return d.ArgErr()
+				}
+				var err error
+				rl.MaxResponseBodySize, err = parseSize(sizeStr)
+				if err != nil {
+					return d.Errf("invalid size: %v", err)
+				}
 			case "max_body_size":
 				var sizeStr string
 				if !d.Args(&sizeStr) {
@@ -318,16 +2677,464 @@ func (rl *RequestLogger) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
 				if err != nil {
 					return d.Errf("invalid size: %v", err)
 				}
+			case "log_entropy":
+				rl.LogEntropy = true
+			case "trust_forwarded":
+				rl.TrustForwarded = true
+			case "trusted_proxies":
+				rl.TrustedProxies = append(rl.TrustedProxies, d.RemainingArgs()...)
+			case "mask_ip_ranges":
+				rl.MaskIPRanges = append(rl.MaskIPRanges, d.RemainingArgs()...)
+			case "log_client_request_count":
+				rl.LogClientRequestCount = true
+			case "client_count_window":
+				var err error
+				if rl.ClientCountWindow, err = parseDurationArg(d); err != nil {
+					return err
+				}
+			case "client_count_max_entries":
+				var err error
+				if rl.ClientCountMaxEntries, err = parseIntArg(d); err != nil {
+					return err
+				}
+			case "log_connection_request_index":
+				rl.LogConnectionRequestIndex = true
+			case "detect_protocol_anomaly":
+				rl.DetectProtocolAnomaly = true
+			case "include_tls":
+				rl.IncludeTLS = true
+			case "detect_rate_anomaly":
+				rl.DetectRateAnomaly = true
+			case "rate_anomaly_multiplier":
+				var err error
+				if rl.RateAnomalyMultiplier, err = parseFloatArg(d); err != nil {
+					return err
+				}
+			case "log_http3":
+				rl.LogHTTP3 = true
+			case "log_uptime":
+				rl.LogUptime = true
+			case "compute_risk_score":
+				rl.ComputeRiskScore = true
+			case "risk_weights":
+				if rl.RiskWeights == nil {
+					rl.RiskWeights = make(map[string]int)
+				}
+				for nesting := d.Nesting(); d.NextBlock(nesting); {
+					signal := d.Val()
+					var weightStr string
+					if !d.Args(&weightStr) {
+						return d.ArgErr()
+					}
+					weight, err := strconv.Atoi(weightStr)
+					if err != nil {
+						return d.Errf("invalid weight for %s: %v", signal, err)
+					}
+					rl.RiskWeights[signal] = weight
+				}
+			case "honeypot_paths":
+				rl.HoneypotPaths = append(rl.HoneypotPaths, d.RemainingArgs()...)
+			case "anomaly_scorer":
+				if !d.Args(&rl.AnomalyScorerName) {
+					return d.ArgErr()
+				}
+			case "anomaly_threshold":
+				var err error
+				if rl.AnomalyThreshold, err = parseFloatArg(d); err != nil {
+					return err
+				}
+			case "signature_denylist":
+				rl.SignatureDenylist = append(rl.SignatureDenylist, d.RemainingArgs()...)
+			case "block_denylisted":
+				rl.BlockDenylisted = true
+			case "auth_result_var":
+				if !d.Args(&rl.AuthResultVar) {
+					return d.ArgErr()
+				}
+			case "generate_trace_id":
+				rl.GenerateTraceID = true
+			case "trace_id_format":
+				if !d.Args(&rl.TraceIDFormat) {
+					return d.ArgErr()
+				}
+			case "request_id_header":
+				if !d.Args(&rl.RequestIDHeader) {
+					return d.ArgErr()
+				}
+			case "generate_request_id":
+				rl.GenerateRequestID = true
+			case "replay_store_size":
+				var err error
+				if rl.ReplayStoreSize, err = parseIntArg(d); err != nil {
+					return err
+				}
+			case "attach_to_span":
+				rl.AttachToSpan = true
+				if d.NextArg() {
+					if d.Val() != "only" {
+						return d.Errf("unknown attach_to_span argument: %s", d.Val())
+					}
+					rl.SpanOnly = true
+				}
+			case "timezone_header":
+				if !d.Args(&rl.TimezoneHeader) {
+					return d.ArgErr()
+				}
+			case "timezone_cookie":
+				if !d.Args(&rl.TimezoneCookie) {
+					return d.ArgErr()
+				}
+			case "experiment_header":
+				if !d.Args(&rl.ExperimentHeader) {
+					return d.ArgErr()
+				}
+			case "experiment_cookie":
+				if !d.Args(&rl.ExperimentCookie) {
+					return d.ArgErr()
+				}
+			case "experiment_variants":
+				rl.ExperimentVariants = append(rl.ExperimentVariants, d.RemainingArgs()...)
+			case "parse_forwarded_header":
+				rl.ParseForwardedHeader = true
+			case "log_locale":
+				rl.LogLocale = true
+			case "build_info":
+				var key, value string
+				if !d.Args(&key, &value) {
+					return d.ArgErr()
+				}
+				if rl.BuildInfo == nil {
+					rl.BuildInfo = make(map[string]string)
+				}
+				rl.BuildInfo[key] = value
+			case "include_build_info":
+				rl.IncludeBuildInfo = true
+			case "log_redirects":
+				rl.LogRedirects = true
+			case "rate_limit_zone_var":
+				if !d.Args(&rl.RateLimitZoneVar) {
+					return d.ArgErr()
+				}
+			case "downstream_service_var":
+				if !d.Args(&rl.DownstreamServiceVar) {
+					return d.ArgErr()
+				}
+			case "feature_flag_var":
+				if !d.Args(&rl.FeatureFlagVar) {
+					return d.ArgErr()
+				}
+			case "context_keys":
+				rl.ContextKeys = append(rl.ContextKeys, d.RemainingArgs()...)
+			case "log_cache_key":
+				rl.LogCacheKey = true
+			case "cache_key_template":
+				if !d.Args(&rl.CacheKeyTemplate) {
+					return d.ArgErr()
+				}
+			case "cache_key_vary":
+				rl.CacheKeyVary = append(rl.CacheKeyVary, d.RemainingArgs()...)
+			case "log_streaming_stats":
+				rl.LogStreamingStats = true
+			case "log_content_negotiation":
+				rl.LogContentNegotiation = true
+			case "apm_format":
+				rl.APMFormat = true
+			case "log_deadline_margin":
+				rl.LogDeadlineMargin = true
+			case "classify_errors":
+				rl.ClassifyErrors = true
+			case "log_upstream":
+				rl.LogUpstream = true
+			case "tenant_header":
+				if !d.Args(&rl.TenantHeader) {
+					return d.ArgErr()
+				}
+			case "multi_format":
+				for nesting := d.Nesting(); d.NextBlock(nesting); {
+					sink := FormatSink{Format: d.Val()}
+					if !d.Args(&sink.Output) {
+						return d.ArgErr()
+					}
+					rl.MultiFormat = append(rl.MultiFormat, sink)
+				}
+			case "tenant_sinks":
+				if rl.TenantSinks == nil {
+					rl.TenantSinks = make(map[string]string)
+				}
+				for nesting := d.Nesting(); d.NextBlock(nesting); {
+					tenant := d.Val()
+					var path string
+					if !d.Args(&path) {
+						return d.ArgErr()
+					}
+					rl.TenantSinks[tenant] = path
+				}
+			case "retention_class":
+				if !d.Args(&rl.RetentionClass) {
+					return d.ArgErr()
+				}
+			case "retention_rule":
+				var kind, pattern, class string
+				if !d.Args(&kind, &pattern, &class) {
+					return d.ArgErr()
+				}
+				rule := RetentionRule{Class: class}
+				switch kind {
+				case "path":
+					rule.Path = pattern
+				case "status":
+					rule.Status = pattern
+				default:
+					return d.Errf("unknown retention_rule condition: %s (expected path or status)", kind)
+				}
+				rl.RetentionRules = append(rl.RetentionRules, rule)
+			case "request_cost":
+				for nesting := d.Nesting(); d.NextBlock(nesting); {
+					rule := CostRule{Method: d.Val()}
+					args := d.RemainingArgs()
+					if len(args) < 2 || len(args) > 3 {
+						return d.ArgErr()
+					}
+					rule.Path = args[0]
+					var err error
+					if rule.Cost, err = strconv.ParseFloat(args[1], 64); err != nil {
+						return d.Errf("invalid request_cost cost: %v", err)
+					}
+					if len(args) == 3 {
+						if rule.PerKB, err = strconv.ParseFloat(args[2], 64); err != nil {
+							return d.Errf("invalid request_cost per_kb: %v", err)
+						}
+					}
+					rl.RequestCost = append(rl.RequestCost, rule)
+				}
+			case "path_override":
+				args := d.RemainingArgs()
+				switch {
+				case len(args) == 2 && args[1] == "skip":
+					rl.PathOverrides = append(rl.PathOverrides, PathOverride{Path: args[0], Skip: true})
+				case len(args) == 1:
+					// The block is parsed like a top-level request_logger block
+					override := PathOverride{Path: args[0], Logger: new(RequestLogger)}
+					if err := override.Logger.UnmarshalCaddyfile(d.NewFromNextSegment()); err != nil {
+						return err
+					}
+					rl.PathOverrides = append(rl.PathOverrides, override)
+				default:
+					return d.ArgErr()
+				}
+			case "replay_detection":
+				rd := new(ReplayDetection)
+				for nesting := d.Nesting(); d.NextBlock(nesting); {
+					switch d.Val() {
+					case "nonce_header":
+						if !d.Args(&rd.NonceHeader) {
+							return d.ArgErr()
+						}
+					case "timestamp_header":
+						if !d.Args(&rd.TimestampHeader) {
+							return d.ArgErr()
+						}
+					case "window":
+						var err error
+						if rd.Window, err = parseDurationArg(d); err != nil {
+							return err
+						}
+					case "max_nonces":
+						var err error
+						if rd.MaxNonces, err = parseIntArg(d); err != nil {
+							return err
+						}
+					default:
+						return d.Errf("unknown replay_detection option: %s", d.Val())
+					}
+				}
+				rl.ReplayDetection = rd
+			case "compress_output":
+				if !d.Args(&rl.CompressOutput) {
+					return d.ArgErr()
+				}
+			case "webhook_url":
+				if !d.Args(&rl.WebhookURL) {
+					return d.ArgErr()
+				}
+			case "webhook_timeout":
+				dur, err := parseDurationArg(d)
+				if err != nil {
+					return err
+				}
+				rl.WebhookTimeout = dur
+			case "webhook_buffer_size":
+				n, err := parseIntArg(d)
+				if err != nil {
+					return err
+				}
+				rl.WebhookBufferSize = n
+			case "output_file":
+				if !d.Args(&rl.OutputFile) {
+					return d.ArgErr()
+				}
+			case "max_size":
+				var sizeStr string
+				if !d.Args(&sizeStr) {
+					return d.ArgErr()
+				}
+				var err error
+				rl.MaxSize, err = parseSize(sizeStr)
+				if err != nil {
+					return d.Errf("invalid size: %v", err)
+				}
+			case "max_age":
+				dur, err := parseDurationArg(d)
+				if err != nil {
+					return err
+				}
+				rl.MaxAge = dur
+			case "max_backups":
+				n, err := parseIntArg(d)
+				if err != nil {
+					return err
+				}
+				rl.MaxBackups = n
+			case "redaction_strategies":
+				if rl.RedactionStrategies == nil {
+					rl.RedactionStrategies = make(map[string]string)
+				}
+				for nesting := d.Nesting(); d.NextBlock(nesting); {
+					target := d.Val()
+					var strategy string
+					if !d.Args(&strategy) {
+						return d.ArgErr()
+					}
+					rl.RedactionStrategies[target] = strategy
+				}
+			case "extra_fields":
+				if rl.ExtraFields == nil {
+					rl.ExtraFields = make(map[string]string)
+				}
+				for nesting := d.Nesting(); d.NextBlock(nesting); {
+					name := d.Val()
+					var value string
+					if !d.Args(&value) {
+						return d.ArgErr()
+					}
+					rl.ExtraFields[name] = value
+				}
+			case "field_types":
+				if rl.FieldTypes == nil {
+					rl.FieldTypes = make(map[string]string)
+				}
+				for nesting := d.Nesting(); d.NextBlock(nesting); {
+					field := d.Val()
+					var typ string
+					if !d.Args(&typ) {
+						return d.ArgErr()
+					}
+					rl.FieldTypes[field] = typ
+				}
+			case "coalesce_window":
+				var err error
+				if rl.CoalesceWindow, err = parseDurationArg(d); err != nil {
+					return err
+				}
+			case "heartbeat_interval":
+				var err error
+				if rl.HeartbeatInterval, err = parseDurationArg(d); err != nil {
+					return err
+				}
+			case "max_body_lines":
+				var err error
+				if rl.MaxBodyLines, err = parseIntArg(d); err != nil {
+					return err
+				}
+			case "large_body_threshold":
+				var sizeStr string
+				if !d.Args(&sizeStr) {
+					return d.ArgErr()
+				}
+				var err error
+				rl.LargeBodyThreshold, err = parseSize(sizeStr)
+				if err != nil {
+					return d.Errf("invalid size: %v", err)
+				}
+			case "log_unexpected_body":
+				rl.LogUnexpectedBody = true
+			case "detect_length_mismatch":
+				rl.DetectLengthMismatch = true
+			case "body_sample_bytes":
+				var sizeStr string
+				if !d.Args(&sizeStr) {
+					return d.ArgErr()
+				}
+				var err error
+				rl.BodySampleBytes, err = parseSize(sizeStr)
+				if err != nil {
+					return d.Errf("invalid size: %v", err)
+				}
+			case "log_read_timing":
+				rl.LogReadTiming = true
+			case "log_backend_read_stall":
+				rl.LogBackendReadStall = true
+			case "backend_read_stall_threshold":
+				var err error
+				if rl.BackendReadStallThreshold, err = parseDurationArg(d); err != nil {
+					return err
+				}
+			case "body_read_timeout":
+				var err error
+				if rl.BodyReadTimeout, err = parseDurationArg(d); err != nil {
+					return err
+				}
 			case "skip_methods":
 				rl.SkipMethods = append(rl.SkipMethods, d.RemainingArgs()...)
+			case "skip_preflight":
+				rl.SkipPreflight = true
+			case "skip_status":
+				rl.SkipStatus = append(rl.SkipStatus, d.RemainingArgs()...)
 			case "skip_paths":
 				rl.SkipPaths = append(rl.SkipPaths, d.RemainingArgs()...)
+			case "skip_paths_regex":
+				rl.SkipPathsRegex = append(rl.SkipPathsRegex, d.RemainingArgs()...)
+			case "skip_paths_glob":
+				rl.SkipPathsGlob = append(rl.SkipPathsGlob, d.RemainingArgs()...)
 			case "include_headers":
 				rl.IncludeHeaders = append(rl.IncludeHeaders, d.RemainingArgs()...)
+			case "redact_query_params":
+				rl.RedactQueryParams = append(rl.RedactQueryParams, d.RemainingArgs()...)
+			case "redact_headers":
+				rl.RedactHeaders = append(rl.RedactHeaders, d.RemainingArgs()...)
+			case "stable_header_order":
+				rl.StableHeaderOrder = true
 			case "exclude_headers":
 				rl.ExcludeHeaders = append(rl.ExcludeHeaders, d.RemainingArgs()...)
 			case "skip_content_types":
 				rl.SkipContentTypes = append(rl.SkipContentTypes, d.RemainingArgs()...)
+			case "sample_rate":
+				rate, err := parseFloatArg(d)
+				if err != nil {
+					return err
+				}
+				rl.SampleRate = &rate
+			case "adaptive_sampling":
+				rl.AdaptiveSampling = true
+			case "keep_errors":
+				rl.KeepErrors = true
+			case "target_logs_per_sec":
+				var err error
+				if rl.TargetLogsPerSec, err = parseFloatArg(d); err != nil {
+					return err
+				}
+			case "propagate_sampling_decision":
+				rl.PropagateSamplingDecision = true
+			case "sampling_header":
+				if !d.Args(&rl.SamplingHeader) {
+					return d.ArgErr()
+				}
+			case "log_skip_reason":
+				rl.LogSkipReason = true
+			case "preserve_order":
+				// Entries are written, and queued for the webhook, in
+				// completion order; there is no reorder buffer
+				return d.Errf("preserve_order is not supported: entries are logged in the order requests complete")
 			default:
 				return d.Errf("unknown directive: %s", d.Val())
 			}
@@ -336,9 +3143,50 @@ func (rl *RequestLogger) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
 	return nil
 }
 
+// parseDurationArg reads a single duration argument from the dispenser
+func parseDurationArg(d *caddyfile.Dispenser) (caddy.Duration, error) {
+	var durStr string
+	if !d.Args(&durStr) {
+		return 0, d.ArgErr()
+	}
+	dur, err := caddy.ParseDuration(durStr)
+	if err != nil {
+		return 0, d.Errf("invalid duration: %v", err)
+	}
+	return caddy.Duration(dur), nil
+}
+
+// parseIntArg reads a single integer argument from the dispenser
+func parseIntArg(d *caddyfile.Dispenser) (int, error) {
+	var numStr string
+	if !d.Args(&numStr) {
+		return 0, d.ArgErr()
+	}
+	num, err := strconv.Atoi(numStr)
+	if err != nil {
+		return 0, d.Errf("invalid number: %v", err)
+	}
+	return num, nil
+}
+
+// parseFloatArg reads a single floating point argument from the dispenser
+func parseFloatArg(d *caddyfile.Dispenser) (float64, error) {
+	var numStr string
+	if !d.Args(&numStr) {
+		return 0, d.ArgErr()
+	}
+	num, err := strconv.ParseFloat(numStr, 64)
+	if err != nil {
+		return 0, d.Errf("invalid number: %v", err)
+	}
+	return num, nil
+}
+
 // Interface guards
 var (
 	_ caddy.Provisioner           = (*RequestLogger)(nil)
+	_ caddy.Validator             = (*RequestLogger)(nil)
+	_ caddy.CleanerUpper          = (*RequestLogger)(nil)
 	_ caddyhttp.MiddlewareHandler = (*RequestLogger)(nil)
 	_ caddyfile.Unmarshaler       = (*RequestLogger)(nil)
-) 
\ No newline at end of file
+)
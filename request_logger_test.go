@@ -0,0 +1,271 @@
+package request_logger
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+// provisionTest provisions and validates rl and replaces its logger with
+// one recording the entries
+func provisionTest(t *testing.T, rl *RequestLogger) *observer.ObservedLogs {
+	t.Helper()
+	if err := rl.Provision(caddy.Context{Context: context.Background()}); err != nil {
+		t.Fatalf("Provision: %v", err)
+	}
+	if err := rl.Validate(); err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+	t.Cleanup(func() { _ = rl.Cleanup() })
+
+	core, logs := observer.New(zapcore.DebugLevel)
+	rl.logger = zap.New(core)
+	return logs
+}
+
+// parseTest parses a request_logger Caddyfile block
+func parseTest(t *testing.T, input string) *RequestLogger {
+	t.Helper()
+	rl := new(RequestLogger)
+	if err := rl.UnmarshalCaddyfile(caddyfile.NewTestDispenser(input)); err != nil {
+		t.Fatalf("UnmarshalCaddyfile: %v", err)
+	}
+	return rl
+}
+
+// serveTest passes r through rl to a handler responding with status and
+// body
+func serveTest(t *testing.T, rl *RequestLogger, r *http.Request, status int, body string) {
+	t.Helper()
+	next := caddyhttp.HandlerFunc(func(w http.ResponseWriter, r *http.Request) error {
+		w.WriteHeader(status)
+		_, err := w.Write([]byte(body))
+		return err
+	})
+	if err := rl.ServeHTTP(httptest.NewRecorder(), r, next); err != nil {
+		t.Fatalf("ServeHTTP: %v", err)
+	}
+}
+
+func TestSampleRateZeroLogsNothing(t *testing.T) {
+	rl := parseTest(t, `request_logger {
+		sample_rate 0
+	}`)
+	if rl.SampleRate == nil || *rl.SampleRate != 0 {
+		t.Fatalf("sample_rate 0 parsed as %v", rl.SampleRate)
+	}
+	logs := provisionTest(t, rl)
+
+	for i := 0; i < 100; i++ {
+		serveTest(t, rl, httptest.NewRequest(http.MethodGet, "/", nil), http.StatusOK, "")
+	}
+	if n := logs.Len(); n != 0 {
+		t.Errorf("sample_rate 0 logged %d of 100 requests", n)
+	}
+}
+
+func TestSampleRateDefaultLogsEverything(t *testing.T) {
+	rl := new(RequestLogger)
+	logs := provisionTest(t, rl)
+
+	for i := 0; i < 10; i++ {
+		serveTest(t, rl, httptest.NewRequest(http.MethodGet, "/", nil), http.StatusOK, "")
+	}
+	if n := logs.Len(); n != 10 {
+		t.Errorf("default sample_rate logged %d of 10 requests", n)
+	}
+}
+
+func TestSampleRateOutOfRange(t *testing.T) {
+	for _, rate := range []float64{-0.1, 1.5} {
+		rate := rate
+		rl := &RequestLogger{SampleRate: &rate}
+		if err := rl.Provision(caddy.Context{Context: context.Background()}); err != nil {
+			t.Fatalf("Provision: %v", err)
+		}
+		if err := rl.Validate(); err == nil {
+			t.Errorf("sample_rate %v accepted", rate)
+		}
+		_ = rl.Cleanup()
+	}
+}
+
+func TestPreserveOrderRejected(t *testing.T) {
+	err := new(RequestLogger).UnmarshalCaddyfile(caddyfile.NewTestDispenser(`request_logger {
+		preserve_order
+	}`))
+	if err == nil || !strings.Contains(err.Error(), "preserve_order is not supported") {
+		t.Errorf("preserve_order: got error %v", err)
+	}
+}
+
+func TestKeepErrorsLogsSampledOutFailures(t *testing.T) {
+	rl := parseTest(t, `request_logger {
+		sample_rate 0
+		keep_errors
+	}`)
+	logs := provisionTest(t, rl)
+
+	for i := 0; i < 20; i++ {
+		serveTest(t, rl, httptest.NewRequest(http.MethodGet, "/fail", nil), http.StatusBadGateway, "")
+	}
+	if n := logs.Len(); n != 20 {
+		t.Fatalf("logged %d of 20 5xx responses", n)
+	}
+	for _, entry := range logs.All() {
+		if kept, _ := entry.ContextMap()["sample_kept_error"].(bool); !kept {
+			t.Fatalf("kept failure not marked with sample_kept_error: %v", entry.ContextMap())
+		}
+	}
+
+	for _, status := range []int{http.StatusNotFound, http.StatusUnprocessableEntity} {
+		serveTest(t, rl, httptest.NewRequest(http.MethodGet, "/missing", nil), status, "")
+	}
+	if n := logs.Len(); n != 22 {
+		t.Fatalf("4xx responses not logged (%d entries)", n)
+	}
+
+	next := caddyhttp.HandlerFunc(func(http.ResponseWriter, *http.Request) error {
+		return errors.New("upstream failed")
+	})
+	_ = rl.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/error", nil), next)
+	if n := logs.Len(); n != 23 {
+		t.Fatalf("handler error not logged (%d entries)", n)
+	}
+
+	for i := 0; i < 100; i++ {
+		serveTest(t, rl, httptest.NewRequest(http.MethodGet, "/ok", nil), http.StatusOK, "")
+	}
+	if n := logs.FilterMessage("Request: GET /ok").Len(); n != 0 {
+		t.Errorf("logged %d of 100 successes at sample_rate 0", n)
+	}
+}
+
+func TestLogSkipReason(t *testing.T) {
+	for _, tc := range []struct {
+		option string
+		path   string
+		status int
+		reason string
+	}{
+		{"path_override /static/* skip", "/static/app.js", http.StatusOK, "path"},
+		{"skip_status 302", "/moved", http.StatusFound, "status"},
+		{"min_duration 1h", "/fast", http.StatusOK, "min_duration"},
+	} {
+		rl := parseTest(t, "request_logger {\nlog_skip_reason\n"+tc.option+"\n}")
+		logs := provisionTest(t, rl)
+
+		serveTest(t, rl, httptest.NewRequest(http.MethodGet, tc.path, nil), tc.status, "")
+		entries := logs.All()
+		if len(entries) != 1 || entries[0].Level != zapcore.DebugLevel || entries[0].ContextMap()["skip_reason"] != tc.reason {
+			t.Errorf("%s: got %v, want one debug entry with skip_reason %s", tc.option, entries, tc.reason)
+		}
+	}
+}
+
+func TestBodyReadTimeOnlyWhenMeasured(t *testing.T) {
+	rl := parseTest(t, `request_logger {
+		include_request_body
+		max_body_size 16
+		body_sample_bytes 8
+		log_read_timing
+	}`)
+	logs := provisionTest(t, rl)
+
+	next := caddyhttp.HandlerFunc(func(w http.ResponseWriter, r *http.Request) error {
+		_, err := io.Copy(io.Discard, r.Body)
+		return err
+	})
+	for _, tc := range []struct {
+		body     string
+		measured bool
+	}{
+		{"short", true},
+		{strings.Repeat("sampled ", 16), false},
+	} {
+		r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tc.body))
+		if err := rl.ServeHTTP(httptest.NewRecorder(), r, next); err != nil {
+			t.Fatalf("ServeHTTP: %v", err)
+		}
+		entries := logs.TakeAll()
+		if len(entries) != 1 {
+			t.Fatalf("got %d entries, want 1", len(entries))
+		}
+		if _, ok := entries[0].ContextMap()["body_read_time"]; ok != tc.measured {
+			t.Errorf("%d byte body: body_read_time logged = %v, want %v", len(tc.body), ok, tc.measured)
+		}
+	}
+}
+
+// TestCleanupDuringServeHTTP mimics a config reload, where Caddy cleans up
+// the old handler while requests in its grace period still use it. Run
+// with -race.
+func TestCleanupDuringServeHTTP(t *testing.T) {
+	dir := t.TempDir()
+	webhook := httptest.NewServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	defer webhook.Close()
+
+	rl := parseTest(t, fmt.Sprintf(`request_logger {
+		output_file %[1]s/out.log
+		multi_format {
+			json %[1]s/all.json
+		}
+		compress_output gzip
+		tenant_header X-Tenant
+		tenant_sinks {
+			acme %[1]s/acme.log
+		}
+		include_request_body
+		artifact_store file://%[1]s/artifacts
+		coalesce_window 5ms
+		webhook_url %[2]s
+		heartbeat_interval 1ms
+	}`, dir, webhook.URL))
+	if err := rl.Provision(caddy.Context{Context: context.Background()}); err != nil {
+		t.Fatalf("Provision: %v", err)
+	}
+
+	next := caddyhttp.HandlerFunc(func(w http.ResponseWriter, r *http.Request) error {
+		_, err := io.Copy(io.Discard, r.Body)
+		return err
+	})
+	start := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			for j := 0; j < 50; j++ {
+				r := httptest.NewRequest(http.MethodPost, fmt.Sprintf("/items/%d", j%5), strings.NewReader("body"))
+				r.Header.Set("X-Tenant", "acme")
+				if err := rl.ServeHTTP(httptest.NewRecorder(), r, next); err != nil {
+					t.Errorf("ServeHTTP: %v", err)
+					return
+				}
+			}
+		}()
+	}
+
+	close(start)
+	if err := rl.Cleanup(); err != nil {
+		t.Errorf("Cleanup: %v", err)
+	}
+	wg.Wait()
+	if err := rl.Cleanup(); err != nil {
+		t.Errorf("second Cleanup: %v", err)
+	}
+}
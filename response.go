@@ -0,0 +1,157 @@
+package request_logger
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+)
+
+// responseRecorder wraps the ResponseWriter to record the status code and
+// the number of bytes written, while passing everything through unchanged
+type responseRecorder struct {
+	*caddyhttp.ResponseWriterWrapper
+	status      int
+	size        int64
+	wroteHeader bool
+
+	// When the final header was written and whether the handler flushed,
+	// which is how streaming responses are told apart
+	headerTime time.Time
+	flushed    bool
+
+	// Copy of the first bytes of the body, nil unless capturing
+	body *bodyCapture
+}
+
+// bodyCapture keeps up to limit bytes written to it and silently drops the
+// rest, so the response keeps flowing to the client past the cap
+type bodyCapture struct {
+	buf   bytes.Buffer
+	limit int
+}
+
+func (c *bodyCapture) Write(p []byte) (int, error) {
+	if room := c.limit - c.buf.Len(); room > 0 {
+		c.buf.Write(p[:min(len(p), room)])
+	}
+	return len(p), nil
+}
+
+func newResponseRecorder(w http.ResponseWriter) *responseRecorder {
+	return &responseRecorder{
+		ResponseWriterWrapper: &caddyhttp.ResponseWriterWrapper{ResponseWriter: w},
+	}
+}
+
+// captureBody makes the recorder keep up to limit bytes of the body
+func (rr *responseRecorder) captureBody(limit int) {
+	rr.body = &bodyCapture{limit: limit}
+}
+
+// capturedBody returns the captured body bytes, or nil if not capturing
+func (rr *responseRecorder) capturedBody() []byte {
+	if rr.body == nil {
+		return nil
+	}
+	return rr.body.buf.Bytes()
+}
+
+// WriteHeader records the final status code; informational 1xx responses
+// may precede it and are passed through without being recorded
+func (rr *responseRecorder) WriteHeader(status int) {
+	if !rr.wroteHeader && status >= 200 {
+		rr.status = status
+		rr.wroteHeader = true
+		rr.headerTime = time.Now()
+	}
+	rr.ResponseWriterWrapper.WriteHeader(status)
+}
+
+func (rr *responseRecorder) Write(p []byte) (int, error) {
+	if !rr.wroteHeader {
+		rr.WriteHeader(http.StatusOK)
+	}
+	n, err := rr.ResponseWriterWrapper.Write(p)
+	rr.size += int64(n)
+	if rr.body != nil {
+		_, _ = rr.body.Write(p[:n])
+	}
+	return n, err
+}
+
+// ReadFrom counts bytes copied through the underlying io.ReaderFrom, which
+// would otherwise bypass Write
+func (rr *responseRecorder) ReadFrom(src io.Reader) (int64, error) {
+	if !rr.wroteHeader {
+		rr.WriteHeader(http.StatusOK)
+	}
+	if rr.body != nil {
+		src = io.TeeReader(src, rr.body)
+	}
+	n, err := rr.ResponseWriterWrapper.ReadFrom(src)
+	rr.size += n
+	return n, err
+}
+
+// Flush implements http.Flusher so streaming responses keep working
+func (rr *responseRecorder) Flush() {
+	if !rr.wroteHeader {
+		rr.WriteHeader(http.StatusOK)
+	}
+	rr.flushed = true
+	_ = http.NewResponseController(rr.ResponseWriterWrapper.ResponseWriter).Flush()
+}
+
+// Hijack implements http.Hijacker so websockets keep working
+func (rr *responseRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, brw, err := http.NewResponseController(rr.ResponseWriterWrapper.ResponseWriter).Hijack()
+	if err == nil && !rr.wroteHeader {
+		rr.status = http.StatusSwitchingProtocols
+		rr.wroteHeader = true
+	}
+	return conn, brw, err
+}
+
+// streaming reports whether the response was streamed: server-sent events,
+// explicitly chunked, or flushed by the handler without a Content-Length
+func (rr *responseRecorder) streaming() bool {
+	header := rr.Header()
+	if strings.HasPrefix(header.Get("Content-Type"), "text/event-stream") {
+		return true
+	}
+	if strings.EqualFold(header.Get("Transfer-Encoding"), "chunked") {
+		return true
+	}
+	return rr.flushed && header.Get("Content-Length") == ""
+}
+
+// responseStatus returns the status code the client receives. Handlers that
+// return an error without writing leave the response to Caddy's error
+// handling, which uses the error's status code.
+func responseStatus(rec *responseRecorder, err error) int {
+	if rec.wroteHeader {
+		return rec.status
+	}
+	if err != nil {
+		var handlerErr caddyhttp.HandlerError
+		if errors.As(err, &handlerErr) && handlerErr.StatusCode != 0 {
+			return handlerErr.StatusCode
+		}
+		return http.StatusInternalServerError
+	}
+	return http.StatusOK
+}
+
+// Interface guards
+var (
+	_ http.Flusher  = (*responseRecorder)(nil)
+	_ http.Hijacker = (*responseRecorder)(nil)
+	_ io.ReaderFrom = (*responseRecorder)(nil)
+)
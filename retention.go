@@ -0,0 +1,86 @@
+package request_logger
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// RetentionRule assigns a retention class to entries matching a path prefix
+// and/or a response status pattern
+type RetentionRule struct {
+	// Path prefix the request must match
+	Path string `json:"path,omitempty"`
+
+	// Status code (e.g. 404) or class (e.g. 5xx) the response must match
+	Status string `json:"status,omitempty"`
+
+	// Retention class logged for matching entries, e.g. 365d
+	Class string `json:"class"`
+}
+
+// validateRetentionRules checks that every rule has a condition, a class
+// and a well-formed status pattern
+func validateRetentionRules(rules []RetentionRule) error {
+	for i, rule := range rules {
+		if rule.Class == "" {
+			return fmt.Errorf("retention rule %d: missing class", i)
+		}
+		if rule.Path == "" && rule.Status == "" {
+			return fmt.Errorf("retention rule %d: needs a path or status", i)
+		}
+		if rule.Status != "" && !validStatusPattern(rule.Status) {
+			return fmt.Errorf("retention rule %d: invalid status %q (expected e.g. 404 or 5xx)", i, rule.Status)
+		}
+	}
+	return nil
+}
+
+// retentionClass returns the class of the first rule matching the request
+// path and response status, or the static retention_class. A status of 0
+// means the response is unknown, so status rules do not match.
+func (rl *RequestLogger) retentionClass(path string, status int) string {
+	for _, rule := range rl.RetentionRules {
+		if rule.Path != "" && !strings.HasPrefix(path, rule.Path) {
+			continue
+		}
+		if rule.Status != "" && (status == 0 || !statusMatches(rule.Status, status)) {
+			continue
+		}
+		return rule.Class
+	}
+	return rl.RetentionClass
+}
+
+// hasStatusRetentionRules reports whether any retention rule depends on the
+// response status
+func (rl *RequestLogger) hasStatusRetentionRules() bool {
+	for _, rule := range rl.RetentionRules {
+		if rule.Status != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// validStatusPattern reports whether pattern is a three digit status code or
+// a status class such as 4xx
+func validStatusPattern(pattern string) bool {
+	if len(pattern) != 3 || pattern[0] < '1' || pattern[0] > '5' {
+		return false
+	}
+	if strings.EqualFold(pattern[1:], "xx") {
+		return true
+	}
+	_, err := strconv.Atoi(pattern)
+	return err == nil
+}
+
+// statusMatches reports whether code matches a status pattern such as 404
+// or 5xx
+func statusMatches(pattern string, code int) bool {
+	if strings.EqualFold(pattern[1:], "xx") {
+		return code/100 == int(pattern[0]-'0')
+	}
+	return strconv.Itoa(code) == pattern
+}
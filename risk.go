@@ -0,0 +1,105 @@
+package request_logger
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Risk signals tallied by compute_risk_score
+const (
+	riskHoneypotPath        = "honeypot_path"
+	riskHighEntropy         = "high_entropy"
+	riskSuspiciousUserAgent = "suspicious_user_agent"
+	riskMissingHeaders      = "missing_headers"
+)
+
+// highEntropyThreshold is the entropy in bits per byte above which a body or
+// query is considered encrypted or obfuscated
+const highEntropyThreshold = 7.0
+
+// defaultRiskWeights are used for signals without a configured weight
+var defaultRiskWeights = map[string]int{
+	riskHoneypotPath:        50,
+	riskHighEntropy:         20,
+	riskSuspiciousUserAgent: 30,
+	riskMissingHeaders:      10,
+}
+
+// defaultHoneypotPaths are paths only scanners are expected to request
+var defaultHoneypotPaths = []string{
+	"/.env",
+	"/.git/",
+	"/wp-login.php",
+	"/wp-admin",
+	"/phpmyadmin",
+}
+
+// scannerUserAgents are user agent fragments of common vulnerability scanners
+var scannerUserAgents = []string{
+	"sqlmap",
+	"nikto",
+	"nmap",
+	"masscan",
+	"zgrab",
+	"nuclei",
+	"dirbuster",
+	"gobuster",
+}
+
+// validateRiskWeights checks that every configured weight names a known signal
+func validateRiskWeights(weights map[string]int) error {
+	for signal := range weights {
+		if _, ok := defaultRiskWeights[signal]; !ok {
+			return fmt.Errorf("unknown risk signal %q", signal)
+		}
+	}
+	return nil
+}
+
+// riskScore returns the weighted sum of the risk signals present in the
+// request, along with the names of the signals that fired
+func (rl *RequestLogger) riskScore(r *http.Request, body []byte) (int, []string) {
+	var signals []string
+
+	honeypots := rl.HoneypotPaths
+	if len(honeypots) == 0 {
+		honeypots = defaultHoneypotPaths
+	}
+	for _, p := range honeypots {
+		if strings.HasPrefix(r.URL.Path, p) {
+			signals = append(signals, riskHoneypotPath)
+			break
+		}
+	}
+
+	if shannonEntropy(body) >= highEntropyThreshold || shannonEntropy([]byte(r.URL.RawQuery)) >= highEntropyThreshold {
+		signals = append(signals, riskHighEntropy)
+	}
+
+	ua := strings.ToLower(r.UserAgent())
+	if ua == "" {
+		signals = append(signals, riskSuspiciousUserAgent)
+	} else {
+		for _, scanner := range scannerUserAgents {
+			if strings.Contains(ua, scanner) {
+				signals = append(signals, riskSuspiciousUserAgent)
+				break
+			}
+		}
+	}
+
+	if r.Header.Get("Accept") == "" {
+		signals = append(signals, riskMissingHeaders)
+	}
+
+	score := 0
+	for _, signal := range signals {
+		weight, ok := rl.RiskWeights[signal]
+		if !ok {
+			weight = defaultRiskWeights[signal]
+		}
+		score += weight
+	}
+	return score, signals
+}
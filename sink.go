@@ -0,0 +1,300 @@
+package request_logger
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+// fileSink is a log file opened by the module itself rather than through
+// Caddy's logging configuration
+type fileSink struct {
+	logger *zap.Logger
+	closer io.Closer
+}
+
+// Output compression for file sinks
+const (
+	compressNone = "none"
+	compressGzip = "gzip"
+	compressZstd = "zstd"
+)
+
+// validCompression reports whether c is a supported compress_output value
+func validCompression(c string) bool {
+	switch c {
+	case "", compressNone, compressGzip, compressZstd:
+		return true
+	}
+	return false
+}
+
+// openFileSink opens (or creates) path for appending and returns a JSON
+// logger writing to it, compressed as requested. All levels are enabled;
+// the module decides the level of each entry itself.
+func openFileSink(path, name, compression string) (*fileSink, error) {
+	ws, closer, err := openLogFile(path, compression)
+	if err != nil {
+		return nil, err
+	}
+	core := zapcore.NewCore(newEncoder(formatJSON), ws, zapcore.DebugLevel)
+
+	return &fileSink{logger: zap.New(core).Named(name), closer: closer}, nil
+}
+
+// openRotatingSink returns a JSON logger writing to path, rotated by
+// lumberjack once the file exceeds maxSize bytes. Rotated files older than
+// maxAge or beyond the newest maxBackups are deleted; zero keeps them all.
+func openRotatingSink(path, name string, maxSize int, maxAge time.Duration, maxBackups int) *fileSink {
+	lj := &lumberjack.Logger{
+		Filename:   path,
+		MaxSize:    int(math.Ceil(float64(maxSize) / (1 << 20))),
+		MaxAge:     int(math.Ceil(maxAge.Hours() / 24)),
+		MaxBackups: maxBackups,
+		LocalTime:  true,
+	}
+	core := zapcore.NewCore(newEncoder(formatJSON), zapcore.AddSync(lj), zapcore.DebugLevel)
+
+	return &fileSink{logger: zap.New(core).Named(name), closer: lj}
+}
+
+// openLogFile opens (or creates) path for appending, compressed as requested
+func openLogFile(path, compression string) (zapcore.WriteSyncer, io.Closer, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, nil, fmt.Errorf("opening log file %s: %v", path, err)
+	}
+
+	if compression != "" && compression != compressNone {
+		cw, err := newCompressWriter(file, compression)
+		if err != nil {
+			file.Close()
+			return nil, nil, err
+		}
+		return zapcore.Lock(cw), cw, nil
+	}
+	return file, file, nil
+}
+
+// Encodings for multi_format sinks
+const (
+	formatJSON    = "json"
+	formatConsole = "console"
+)
+
+// newEncoder returns the encoder for a multi_format encoding
+func newEncoder(format string) zapcore.Encoder {
+	encoderConfig := zap.NewProductionEncoderConfig()
+	encoderConfig.EncodeTime = zapcore.ISO8601TimeEncoder
+	if format == formatConsole {
+		encoderConfig.EncodeLevel = zapcore.CapitalLevelEncoder
+		return zapcore.NewConsoleEncoder(encoderConfig)
+	}
+	return zapcore.NewJSONEncoder(encoderConfig)
+}
+
+// FormatSink is an additional destination for log entries, written in its
+// own encoding
+type FormatSink struct {
+	// Encoding of the entries: json or console
+	Format string `json:"format"`
+
+	// stdout, stderr or the path of a file to append to
+	Output string `json:"output"`
+}
+
+// validateFormatSinks checks that every sink has a known format and an
+// output
+func validateFormatSinks(sinks []FormatSink) error {
+	for _, sink := range sinks {
+		if sink.Format != formatJSON && sink.Format != formatConsole {
+			return fmt.Errorf("invalid multi_format format %q (expected json or console)", sink.Format)
+		}
+		if sink.Output == "" {
+			return fmt.Errorf("multi_format %s sink requires an output", sink.Format)
+		}
+	}
+	return nil
+}
+
+// formatSinks tees log entries into cores with different encodings and
+// destinations
+type formatSinks struct {
+	cores   []zapcore.Core
+	closers []io.Closer
+}
+
+// openFormatSinks opens the outputs of sinks; files are compressed as
+// requested, the standard streams never are
+func openFormatSinks(sinks []FormatSink, compression string) (*formatSinks, error) {
+	fs := new(formatSinks)
+	for _, sink := range sinks {
+		var ws zapcore.WriteSyncer
+		switch sink.Output {
+		case "stdout":
+			ws = zapcore.Lock(os.Stdout)
+		case "stderr":
+			ws = zapcore.Lock(os.Stderr)
+		default:
+			file, closer, err := openLogFile(sink.Output, compression)
+			if err != nil {
+				fs.Close()
+				return nil, err
+			}
+			ws = file
+			fs.closers = append(fs.closers, closer)
+		}
+		fs.cores = append(fs.cores, zapcore.NewCore(newEncoder(sink.Format), ws, zapcore.DebugLevel))
+	}
+	return fs, nil
+}
+
+// Tee returns a logger writing to logger and every sink
+func (fs *formatSinks) Tee(logger *zap.Logger) *zap.Logger {
+	return logger.WithOptions(zap.WrapCore(func(core zapcore.Core) zapcore.Core {
+		return zapcore.NewTee(append([]zapcore.Core{core}, fs.cores...)...)
+	}))
+}
+
+// Close syncs the sinks and closes their files
+func (fs *formatSinks) Close() {
+	for _, core := range fs.cores {
+		_ = core.Sync()
+	}
+	for _, closer := range fs.closers {
+		_ = closer.Close()
+	}
+}
+
+// flushWriteCloser is a compressing writer such as gzip.Writer or
+// zstd.Encoder
+type flushWriteCloser interface {
+	io.WriteCloser
+	Flush() error
+}
+
+// compressWriter compresses log records into a file. Every record is
+// flushed as soon as it is written, so a reader (or a crash) never sees a
+// partial record, and the file stays decompressible up to the last
+// complete record even before the stream is closed. Flushing per record
+// costs some compression ratio compared to buffering.
+type compressWriter struct {
+	enc  flushWriteCloser
+	file *os.File
+
+	// mu serializes writes with Close, which may run while requests that
+	// are still in flight log during a config reload
+	mu     sync.Mutex
+	closed bool
+}
+
+func newCompressWriter(file *os.File, compression string) (*compressWriter, error) {
+	var enc flushWriteCloser
+	switch compression {
+	case compressGzip:
+		enc = gzip.NewWriter(file)
+	case compressZstd:
+		zw, err := zstd.NewWriter(file)
+		if err != nil {
+			return nil, err
+		}
+		enc = zw
+	default:
+		return nil, fmt.Errorf("unsupported compression %q", compression)
+	}
+	return &compressWriter{enc: enc, file: file}, nil
+}
+
+// Write compresses one record and flushes it to the file
+func (w *compressWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return 0, os.ErrClosed
+	}
+	n, err := w.enc.Write(p)
+	if err != nil {
+		return n, err
+	}
+	return n, w.enc.Flush()
+}
+
+// Sync flushes compressed data and syncs the file
+func (w *compressWriter) Sync() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return os.ErrClosed
+	}
+	if err := w.enc.Flush(); err != nil {
+		return err
+	}
+	return w.file.Sync()
+}
+
+// Close ends the compressed stream and closes the file
+func (w *compressWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return os.ErrClosed
+	}
+	w.closed = true
+	err := w.enc.Close()
+	if closeErr := w.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// Close flushes the logger and closes the underlying file
+func (s *fileSink) Close() error {
+	_ = s.logger.Sync()
+	return s.closer.Close()
+}
+
+// tenantSinks routes log entries to a separate file per tenant
+type tenantSinks struct {
+	sinks map[string]*fileSink
+}
+
+// newTenantSinks opens one file sink per tenant in paths. tee adds the
+// sinks that receive every entry regardless of tenant to each logger.
+func newTenantSinks(paths map[string]string, name, compression string, tee func(*zap.Logger) *zap.Logger) (*tenantSinks, error) {
+	t := &tenantSinks{sinks: make(map[string]*fileSink, len(paths))}
+	for tenant, path := range paths {
+		sink, err := openFileSink(path, name, compression)
+		if err != nil {
+			t.Close()
+			return nil, err
+		}
+		sink.logger = tee(sink.logger)
+		t.sinks[tenant] = sink
+	}
+	return t, nil
+}
+
+// Logger returns the logger for tenant, or fallback if the tenant has no
+// sink of its own
+func (t *tenantSinks) Logger(tenant string, fallback *zap.Logger) *zap.Logger {
+	if sink, ok := t.sinks[tenant]; ok {
+		return sink.logger
+	}
+	return fallback
+}
+
+// Close closes all tenant files
+func (t *tenantSinks) Close() {
+	for _, sink := range t.sinks {
+		_ = sink.Close()
+	}
+}
@@ -0,0 +1,60 @@
+package request_logger
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestTenantEntriesReachSharedSinks(t *testing.T) {
+	dir := t.TempDir()
+	var mu sync.Mutex
+	var delivered strings.Builder
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		delivered.Write(body)
+		mu.Unlock()
+	}))
+	defer webhook.Close()
+
+	rl := parseTest(t, fmt.Sprintf(`request_logger {
+		tenant_header X-Tenant
+		tenant_sinks {
+			acme %[1]s/acme.log
+		}
+		multi_format {
+			json %[1]s/all.json
+		}
+		webhook_url %[2]s
+	}`, dir, webhook.URL))
+	provisionTest(t, rl)
+
+	r := httptest.NewRequest(http.MethodGet, "/tenant-path", nil)
+	r.Header.Set("X-Tenant", "acme")
+	serveTest(t, rl, r, http.StatusOK, "")
+	if err := rl.Cleanup(); err != nil {
+		t.Fatalf("Cleanup: %v", err)
+	}
+
+	for _, name := range []string{"acme.log", "all.json"} {
+		content, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(content), "/tenant-path") {
+			t.Errorf("%s is missing the tenant entry: %q", name, content)
+		}
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if !strings.Contains(delivered.String(), "/tenant-path") {
+		t.Errorf("webhook is missing the tenant entry: %q", delivered.String())
+	}
+}
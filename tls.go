@@ -0,0 +1,29 @@
+package request_logger
+
+import (
+	"crypto/tls"
+
+	"go.uber.org/zap"
+)
+
+// tlsFields returns the negotiated TLS version, cipher suite and server
+// name of a connection with human-readable names, e.g. TLS 1.3 and
+// TLS_AES_128_GCM_SHA256, and for mutual TLS the leaf client certificate
+func tlsFields(state *tls.ConnectionState) []zap.Field {
+	fields := []zap.Field{
+		zap.String("tls_version", tls.VersionName(state.Version)),
+		zap.String("tls_cipher_suite", tls.CipherSuiteName(state.CipherSuite)),
+	}
+	if state.ServerName != "" {
+		fields = append(fields, zap.String("tls_sni", state.ServerName))
+	}
+	if len(state.PeerCertificates) > 0 {
+		leaf := state.PeerCertificates[0]
+		fields = append(fields,
+			zap.String("client_cert_subject", leaf.Subject.String()),
+			zap.String("client_cert_issuer", leaf.Issuer.String()),
+			zap.String("client_cert_serial", leaf.SerialNumber.String()),
+		)
+	}
+	return fields
+}
@@ -0,0 +1,181 @@
+package request_logger
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// webhookSink POSTs log entries as JSON to an HTTP endpoint. Entries are
+// sent by a single background worker so a slow endpoint never blocks
+// request handling; when the buffer is full new entries are dropped and
+// counted.
+type webhookSink struct {
+	url     string
+	client  *http.Client
+	logger  *zap.Logger
+	queue   chan []byte
+	done    chan struct{}
+	dropped atomic.Uint64
+
+	// ctx is cancelled when Close gives up waiting for queued entries
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu     sync.RWMutex
+	closed bool
+}
+
+// newWebhookSink starts the worker for endpoint. Delivery errors are
+// reported to logger, which must not itself write to the webhook.
+func newWebhookSink(endpoint string, timeout time.Duration, bufferSize int, logger *zap.Logger) (*webhookSink, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid webhook_url %q (expected an http(s):// URL)", endpoint)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &webhookSink{
+		url:    endpoint,
+		client: &http.Client{Timeout: timeout},
+		logger: logger,
+		queue:  make(chan []byte, bufferSize),
+		done:   make(chan struct{}),
+		ctx:    ctx,
+		cancel: cancel,
+	}
+	go s.run()
+	return s, nil
+}
+
+// Tee returns a logger writing to logger and the webhook
+func (s *webhookSink) Tee(logger *zap.Logger) *zap.Logger {
+	core := &webhookCore{LevelEnabler: zapcore.DebugLevel, enc: newEncoder(formatJSON), sink: s}
+	return logger.WithOptions(zap.WrapCore(func(c zapcore.Core) zapcore.Core {
+		return zapcore.NewTee(c, core)
+	}))
+}
+
+// Enqueue schedules an encoded entry for delivery, dropping it if the
+// buffer is full or the sink is closed
+func (s *webhookSink) Enqueue(entry []byte) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.closed {
+		s.dropped.Add(1)
+		countWebhookDropped()
+		return
+	}
+	select {
+	case s.queue <- entry:
+	default:
+		s.dropped.Add(1)
+		countWebhookDropped()
+	}
+}
+
+// Close stops accepting entries and waits until deadline for queued
+// entries to be sent. Entries still queued at the deadline are dropped.
+func (s *webhookSink) Close(deadline time.Time) {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return
+	}
+	s.closed = true
+	close(s.queue)
+	s.mu.Unlock()
+
+	if !waitUntil(s.done, deadline) {
+		s.cancel()
+		<-s.done
+	}
+	s.cancel()
+	if dropped := s.dropped.Load(); dropped > 0 {
+		s.logger.Warn("webhook entries dropped",
+			zap.String("webhook_url", s.url),
+			zap.Uint64("dropped", dropped),
+		)
+	}
+}
+
+func (s *webhookSink) run() {
+	defer close(s.done)
+	for entry := range s.queue {
+		if s.ctx.Err() != nil {
+			s.dropped.Add(1)
+			continue
+		}
+		if err := s.send(entry); err != nil {
+			s.logger.Error("sending log entry to webhook",
+				zap.String("webhook_url", s.url),
+				zap.Error(err),
+			)
+		}
+	}
+}
+
+// send POSTs one entry
+func (s *webhookSink) send(entry []byte) error {
+	req, err := http.NewRequestWithContext(s.ctx, http.MethodPost, s.url, bytes.NewReader(entry))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+// webhookCore encodes entries as JSON and hands them to a webhookSink
+type webhookCore struct {
+	zapcore.LevelEnabler
+	enc  zapcore.Encoder
+	sink *webhookSink
+}
+
+func (c *webhookCore) With(fields []zap.Field) zapcore.Core {
+	enc := c.enc.Clone()
+	for _, f := range fields {
+		f.AddTo(enc)
+	}
+	return &webhookCore{LevelEnabler: c.LevelEnabler, enc: enc, sink: c.sink}
+}
+
+func (c *webhookCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(ent.Level) {
+		return ce.AddCore(ent, c)
+	}
+	return ce
+}
+
+func (c *webhookCore) Write(ent zapcore.Entry, fields []zap.Field) error {
+	buf, err := c.enc.EncodeEntry(ent, fields)
+	if err != nil {
+		return err
+	}
+	entry := bytes.TrimSuffix(bytes.Clone(buf.Bytes()), []byte("\n"))
+	buf.Free()
+	c.sink.Enqueue(entry)
+	return nil
+}
+
+func (c *webhookCore) Sync() error {
+	return nil
+}